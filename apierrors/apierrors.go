@@ -0,0 +1,175 @@
+// Package apierrors holds the typed errors of the API, every error has a stable code and is rendered as an
+// RFC 7807 problem (application/problem+json).
+package apierrors
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// ErrConflict is wrapped by the errors of hooks that conflict with the stored state, e.g.
+// fmt.Errorf("%w: the invoice is already paid", apierrors.ErrConflict), they're answered with 409
+var ErrConflict = errors.New("conflict")
+
+// internalDetail replaces the message of the internal errors, which may tell about the server
+const internalDetail = "an internal error occurred, please retry later"
+
+// Code is the stable machine readable code of an error, clients should rely on it rather than the detail
+type Code string
+
+const (
+	BadRequest           Code = "bad_request"
+	Unauthorized         Code = "unauthorized"
+	Forbidden            Code = "forbidden"
+	NotFound             Code = "not_found"
+	Conflict             Code = "conflict"
+	PreconditionRequired Code = "precondition_required"
+	Validation           Code = "validation"
+	DbUnavailable        Code = "db_unavailable"
+	Internal             Code = "internal"
+)
+
+var statuses = map[Code]int{
+	BadRequest:           http.StatusBadRequest,
+	Unauthorized:         http.StatusUnauthorized,
+	Forbidden:            http.StatusForbidden,
+	NotFound:             http.StatusNotFound,
+	Conflict:             http.StatusConflict,
+	PreconditionRequired: http.StatusPreconditionRequired,
+	Validation:           http.StatusUnprocessableEntity,
+	DbUnavailable:        http.StatusServiceUnavailable,
+	Internal:             http.StatusInternalServerError,
+}
+
+// Error is an API error, Field names the offending field and Extras are added as extension members of the
+// problem, e.g. the invalid fields of a validation error
+type Error struct {
+	Code   Code
+	Status int
+	Detail string
+	Field  string
+	Extras map[string]any
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func New(code Code, detail string) *Error {
+	return &Error{Code: code, Status: StatusOf(code), Detail: detail}
+}
+
+func Newf(code Code, format string, args ...any) *Error {
+	return New(code, fmt.Sprintf(format, args...))
+}
+
+// Wrap keeps err as the cause, its message is the detail except for internal errors which get a generic one
+func Wrap(code Code, err error) *Error {
+	detail := err.Error()
+	if code == Internal {
+		detail = internalDetail
+	}
+	apiErr := New(code, detail)
+	apiErr.Err = err
+	return apiErr
+}
+
+func (e *Error) WithField(field string) *Error {
+	e.Field = field
+	return e
+}
+
+func (e *Error) With(key string, value any) *Error {
+	if e.Extras == nil {
+		e.Extras = map[string]any{}
+	}
+	e.Extras[key] = value
+	return e
+}
+
+// StatusOf is the HTTP status of the code, 500 for unknown codes
+func StatusOf(code Code) int {
+	if status, ok := statuses[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Codes lists the codes by their status, e.g. to document them
+func Codes() []Code {
+	codes := make([]Code, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return statuses[codes[i]] < statuses[codes[j]]
+	})
+	return codes
+}
+
+// From classifies err: API errors are kept, missing records, postgres and connection errors get their code
+// and anything else gets the fallback code
+func From(err error, fallback Code) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &Error{Code: NotFound, Status: StatusOf(NotFound), Detail: "Record not found!", Err: err}
+	}
+	if dbErr := fromDatabase(err); dbErr != nil {
+		return dbErr
+	}
+	if errors.Is(err, ErrConflict) {
+		return Wrap(Conflict, err)
+	}
+	return Wrap(fallback, err)
+}
+
+// Respond renders err as a problem, errors that aren't API errors are internal ones
+func Respond(c *gin.Context, err error) {
+	apiErr := From(err, Internal)
+	if apiErr.Status >= http.StatusInternalServerError {
+		cause := err
+		if apiErr.Err != nil {
+			cause = apiErr.Err
+		}
+		log.Printf("%s %s failed: %v", c.Request.Method, c.Request.URL.Path, cause)
+	}
+	problem := gin.H{}
+	for key, value := range apiErr.Extras {
+		problem[key] = value
+	}
+	problem["type"] = "about:blank"
+	problem["title"] = http.StatusText(apiErr.Status)
+	problem["status"] = apiErr.Status
+	problem["detail"] = apiErr.Detail
+	problem["code"] = apiErr.Code
+	problem["instance"] = c.Request.URL.Path
+	// Kept for the clients reading the former {"error": ...} bodies
+	problem["error"] = apiErr.Detail
+	if apiErr.Field != "" {
+		problem["field"] = apiErr.Field
+	}
+	c.Header("Content-Type", ProblemContentType)
+	c.JSON(apiErr.Status, problem)
+}
+
+// Abort responds with err and stops the handler chain, it's meant for middlewares
+func Abort(c *gin.Context, err error) {
+	Respond(c, err)
+	c.Abort()
+}
@@ -0,0 +1,65 @@
+package apierrors
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// keyDetail reads the column out of details like `Key (email)=(a@b.c) already exists.`
+var keyDetail = regexp.MustCompile(`^Key \(([^)]+)\)=`)
+
+// fromDatabase maps the constraint violations and the connection failures of postgres, nil for other errors
+func fromDatabase(err error) *Error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return fromPgError(pgErr)
+	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	if errors.As(err, &connectErr) || errors.As(err, &netErr) || errors.Is(err, driver.ErrBadConn) {
+		apiErr := New(DbUnavailable, "the database is unavailable, please retry later")
+		apiErr.Err = err
+		return apiErr
+	}
+	return nil
+}
+
+func fromPgError(pgErr *pgconn.PgError) *Error {
+	field := pgErr.ColumnName
+	if match := keyDetail.FindStringSubmatch(pgErr.Detail); match != nil {
+		field = match[1]
+	}
+	var apiErr *Error
+	switch {
+	case pgErr.Code == "23505":
+		apiErr = Newf(Conflict, "a record with this %s already exists", field).WithField(field)
+	case pgErr.Code == "23503" && strings.Contains(pgErr.Detail, "is still referenced"):
+		apiErr = Newf(Conflict, "the record is still referenced by %s", pgErr.TableName)
+	case pgErr.Code == "23503":
+		apiErr = invalidField(field, "doesn't reference an existing record")
+	case pgErr.Code == "23502":
+		apiErr = invalidField(field, "is required")
+	case pgErr.Code == "23514":
+		apiErr = Newf(Validation, "the %s check failed", pgErr.ConstraintName)
+	case pgErr.Code == "40001" || pgErr.Code == "40P01":
+		apiErr = New(Conflict, "the record was modified concurrently, please retry")
+	case strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "57P") || pgErr.Code == "53300":
+		apiErr = New(DbUnavailable, "the database is unavailable, please retry later")
+	default:
+		return nil
+	}
+	apiErr.Err = pgErr
+	return apiErr
+}
+
+// invalidField is a validation error of a single field, listed in fields like the model validation errors
+func invalidField(field string, message string) *Error {
+	return Newf(Validation, "%s %s", field, message).
+		WithField(field).
+		With("fields", map[string]string{field: message})
+}
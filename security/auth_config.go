@@ -2,31 +2,47 @@ package security
 
 import (
 	"log"
-	"net/http"
+	"reflect"
+	"slices"
+	"strings"
 
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
 	"github.com/ahmedsaleh747/go-creative-utils/shared"
 	"github.com/ahmedsaleh747/go-creative-utils/storage"
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware(claims shared.IdentityClaims, skipPath string) gin.HandlerFunc {
+// AuthMiddleware verifies the bearer token of every request except the POST requests to the skip paths,
+// e.g. the login and refresh endpoints
+func AuthMiddleware(claims shared.IdentityClaims, skipPaths ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if c.Request.Method == "POST" && c.Request.URL.Path == skipPath {
+		if c.Request.Method == "POST" && slices.Contains(skipPaths, c.Request.URL.Path) {
 			c.Next()
 			return
 		}
 
 		tokenStr := c.GetHeader("Authorization")
 		if tokenStr == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "No token provided"})
-			c.Abort()
+			apierrors.Abort(c, apierrors.New(apierrors.Unauthorized, "No token provided"))
 			return
 		}
 
-		tokenStr = tokenStr[len("Bearer "):]
-		if err := VerifyToken(tokenStr, claims); err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
+		// Every request gets its own claims instance, they are shared with the next handlers
+		claims := newClaims(claims)
+		tokenStr, _ = strings.CutPrefix(tokenStr, "Bearer ")
+		// A token without an id can't be revoked, e.g. one issued before the logout was supported
+		if err := VerifyToken(tokenStr, claims); err != nil || claims.GetStandardClaims().Id == "" {
+			apierrors.Abort(c, apierrors.New(apierrors.Unauthorized, "Invalid token"))
+			return
+		}
+		revoked, err := storage.IsAccessTokenRevoked(storage.DbFromContext(c), claims.GetStandardClaims().Id)
+		if err != nil {
+			log.Printf("Failed to check the token revocation: %v", err)
+			apierrors.Abort(c, apierrors.New(apierrors.DbUnavailable, "the token can't be checked, please retry later"))
+			return
+		}
+		if revoked {
+			apierrors.Abort(c, apierrors.New(apierrors.Unauthorized, "Token revoked"))
 			return
 		}
 
@@ -48,33 +64,15 @@ func WithRole(allowedRole string) gin.HandlerFunc {
 
 func WithRoles(allowedRoles []string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userMeta, exists := c.Get("user")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-			c.Abort()
-			return
-		}
-
-		// Cast userRole to Role struct
-		meta, ok := userMeta.(*shared.UserMeta)
+		claims, ok := requestClaims(c)
 		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid role data"})
-			c.Abort()
+			apierrors.Abort(c, apierrors.New(apierrors.Unauthorized, "unauthorized"))
 			return
 		}
 
 		// Check if the user's role is in the list of allowed roles
-		roleAllowed := false
-		for _, allowedRole := range allowedRoles {
-			if meta.Role == allowedRole {
-				roleAllowed = true
-				break
-			}
-		}
-
-		if !roleAllowed {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-			c.Abort()
+		if !slices.Contains(allowedRoles, claims.GetRole()) {
+			apierrors.Abort(c, apierrors.New(apierrors.Forbidden, "forbidden"))
 			return
 		}
 
@@ -90,11 +88,13 @@ func Login(c *gin.Context, user storage.Identity, claims shared.IdentityClaims)
 	claims.SetClaims(user)
 	log.Printf("Login succedded for user: %s[%s]", claims.GetUsername(), claims.GetRole())
 
-	token, err := GenerateToken(claims)
+	db, err := storage.GetDb(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
 		return
 	}
+	issueTokens(c, db, claims, "")
+}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+func newClaims(claims shared.IdentityClaims) shared.IdentityClaims {
+	return reflect.New(reflect.TypeOf(claims).Elem()).Interface().(shared.IdentityClaims)
 }
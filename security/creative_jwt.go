@@ -1,6 +1,8 @@
 package security
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"time"
@@ -10,25 +12,57 @@ import (
 	"github.com/dgrijalva/jwt-go"
 )
 
-const expirationHours = 48
+var accessTokenTTL = 15 * time.Minute
+var refreshTokenTTL = 30 * 24 * time.Hour
 
-var jwtKey = []byte{}
+var keySet = &KeySet{keys: map[string]*SigningKey{}}
 
-// Should be called from the child modules to configure the jwtKey“
-func ConfigureJWT(appJwtKey []byte) {
-	jwtKey = appJwtKey
+// Should be called from the child modules to configure the jwtKey“, a bad key is returned as an error and
+// leaves the previous key set in place
+func ConfigureJWT(appJwtKey []byte) error {
+	signingKey, err := NewSigningKey("default", appJwtKey)
+	if err != nil {
+		return err
+	}
+	appKeySet, err := NewKeySet(signingKey)
+	if err != nil {
+		return err
+	}
+	keySet = appKeySet
+	return nil
+}
+
+// ConfigureKeySet replaces ConfigureJWT for asymmetric algorithms and key rotation
+func ConfigureKeySet(appKeySet *KeySet) {
+	keySet = appKeySet
+}
+
+// Should be called from the child modules to change the default 15 minutes access and 30 days refresh tokens
+func ConfigureTokenLifetimes(accessTTL time.Duration, refreshTTL time.Duration) {
+	accessTokenTTL = accessTTL
+	refreshTokenTTL = refreshTTL
 }
 
 func GenerateToken(claims shared.IdentityClaims) (string, error) {
-	expirationTime := time.Now().Add(expirationHours * time.Hour)
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	expirationTime := time.Now().Add(accessTokenTTL)
 	claims.SetStandardClaims(
 		jwt.StandardClaims{
+			Id:        jti,
 			IssuedAt:  time.Now().Unix(),
 			ExpiresAt: expirationTime.Unix(),
 		})
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	signingKey, err := keySet.signingKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(signingKey.Method, claims)
+	token.Header["kid"] = signingKey.Id
+	tokenString, err := token.SignedString(signingKey.signKey)
 	if err != nil {
 		return "", err
 	}
@@ -36,9 +70,7 @@ func GenerateToken(claims shared.IdentityClaims) (string, error) {
 }
 
 func VerifyToken(tokenString string, claims shared.IdentityClaims) error {
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, keySet.verificationKey)
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrSignatureInvalid) {
@@ -52,3 +84,11 @@ func VerifyToken(tokenString string, claims shared.IdentityClaims) error {
 	}
 	return nil
 }
+
+func randomToken(size int) (string, error) {
+	tokenBytes := make([]byte, size)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(tokenBytes), nil
+}
@@ -0,0 +1,209 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// SigningKey is a JWT key pinned to a single algorithm, keys without a private part only verify tokens
+type SigningKey struct {
+	Id        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewSigningKey detects the algorithm from the key type: []byte for HS256, RSA keys for RS256,
+// P-256 ECDSA keys for ES256 and Ed25519 keys for EdDSA. Public keys create verification only keys.
+func NewSigningKey(id string, key interface{}) (*SigningKey, error) {
+	signingKey := &SigningKey{Id: id}
+	switch typedKey := key.(type) {
+	case []byte:
+		signingKey.Method, signingKey.signKey, signingKey.verifyKey = jwt.SigningMethodHS256, typedKey, typedKey
+	case *rsa.PrivateKey:
+		signingKey.Method, signingKey.signKey, signingKey.verifyKey = jwt.SigningMethodRS256, typedKey, &typedKey.PublicKey
+	case *rsa.PublicKey:
+		signingKey.Method, signingKey.verifyKey = jwt.SigningMethodRS256, typedKey
+	case *ecdsa.PrivateKey:
+		if typedKey.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("key %s: only P-256 ECDSA keys are supported", id)
+		}
+		signingKey.Method, signingKey.signKey, signingKey.verifyKey = jwt.SigningMethodES256, typedKey, &typedKey.PublicKey
+	case *ecdsa.PublicKey:
+		if typedKey.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("key %s: only P-256 ECDSA keys are supported", id)
+		}
+		signingKey.Method, signingKey.verifyKey = jwt.SigningMethodES256, typedKey
+	case ed25519.PrivateKey:
+		signingKey.Method, signingKey.signKey, signingKey.verifyKey = SigningMethodEdDSA, typedKey, typedKey.Public()
+	case ed25519.PublicKey:
+		signingKey.Method, signingKey.verifyKey = SigningMethodEdDSA, typedKey
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", id, key)
+	}
+	return signingKey, nil
+}
+
+// ParseSigningKeyPEM creates a key from a PKCS#1, PKCS#8, SEC 1 or PKIX PEM block
+func ParseSigningKeyPEM(id string, pemBytes []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("key %s: no PEM block found", id)
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return NewSigningKey(id, key)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return NewSigningKey(id, key)
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return NewSigningKey(id, key)
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return NewSigningKey(id, key)
+	}
+	return nil, fmt.Errorf("key %s: unsupported PEM block %s", id, block.Type)
+}
+
+func (key *SigningKey) CanSign() bool {
+	return key.signKey != nil
+}
+
+// KeySet holds the active keys, one of them signs new tokens while all of them verify, which allows
+// rotating keys without invalidating the tokens already issued
+type KeySet struct {
+	mu         sync.RWMutex
+	keys       map[string]*SigningKey
+	signingKid string
+}
+
+func NewKeySet(signingKey *SigningKey, verificationKeys ...*SigningKey) (*KeySet, error) {
+	keySet := &KeySet{keys: map[string]*SigningKey{}}
+	for _, key := range append([]*SigningKey{signingKey}, verificationKeys...) {
+		if err := keySet.Add(key); err != nil {
+			return nil, err
+		}
+	}
+	if err := keySet.SetSigningKey(signingKey.Id); err != nil {
+		return nil, err
+	}
+	return keySet, nil
+}
+
+func (keySet *KeySet) Add(key *SigningKey) error {
+	keySet.mu.Lock()
+	defer keySet.mu.Unlock()
+	if _, exists := keySet.keys[key.Id]; exists {
+		return fmt.Errorf("key %s already exists", key.Id)
+	}
+	keySet.keys[key.Id] = key
+	return nil
+}
+
+func (keySet *KeySet) Remove(id string) error {
+	keySet.mu.Lock()
+	defer keySet.mu.Unlock()
+	if id == keySet.signingKid {
+		return fmt.Errorf("key %s is the signing key", id)
+	}
+	delete(keySet.keys, id)
+	return nil
+}
+
+func (keySet *KeySet) SetSigningKey(id string) error {
+	keySet.mu.Lock()
+	defer keySet.mu.Unlock()
+	key, exists := keySet.keys[id]
+	if !exists {
+		return fmt.Errorf("key %s not found", id)
+	}
+	if !key.CanSign() {
+		return fmt.Errorf("key %s has no private key", id)
+	}
+	keySet.signingKid = id
+	return nil
+}
+
+func (keySet *KeySet) signingKey() (*SigningKey, error) {
+	keySet.mu.RLock()
+	defer keySet.mu.RUnlock()
+	key, exists := keySet.keys[keySet.signingKid]
+	if !exists {
+		return nil, errors.New("no signing key configured")
+	}
+	return key, nil
+}
+
+// verificationKey is the jwt.Keyfunc, it resolves the key by the kid header and pins its algorithm.
+// Tokens without kid are verified with the signing key, as issued before the key set existed.
+func (keySet *KeySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	keySet.mu.RLock()
+	defer keySet.mu.RUnlock()
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = keySet.signingKid
+	}
+	key, exists := keySet.keys[kid]
+	if !exists {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if token.Method == nil || token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v for key %q", token.Header["alg"], kid)
+	}
+	return key.verifyKey, nil
+}
+
+// JWKS returns the public keys as a JSON Web Key Set, symmetric keys are never published
+func (keySet *KeySet) JWKS() map[string]interface{} {
+	keySet.mu.RLock()
+	defer keySet.mu.RUnlock()
+	jwks := []map[string]string{}
+	for _, key := range keySet.keys {
+		if jwk := key.jwk(); jwk != nil {
+			jwks = append(jwks, jwk)
+		}
+	}
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i]["kid"] < jwks[j]["kid"] })
+	return map[string]interface{}{"keys": jwks}
+}
+
+func (key *SigningKey) jwk() map[string]string {
+	jwk := map[string]string{"kid": key.Id, "alg": key.Method.Alg(), "use": "sig"}
+	switch publicKey := key.verifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case *ecdsa.PublicKey:
+		jwk["kty"] = "EC"
+		jwk["crv"] = "P-256"
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(publicKey.X.FillBytes(make([]byte, 32)))
+		jwk["y"] = base64.RawURLEncoding.EncodeToString(publicKey.Y.FillBytes(make([]byte, 32)))
+	case ed25519.PublicKey:
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(publicKey)
+	default:
+		return nil
+	}
+	return jwk
+}
+
+// JWKSHandler publishes the public keys, usually routed as GET /.well-known/jwks.json
+func JWKSHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, keySet.JWKS())
+}
@@ -0,0 +1,64 @@
+package security
+
+import (
+	"strings"
+
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
+	"github.com/ahmedsaleh747/go-creative-utils/shared"
+	"github.com/ahmedsaleh747/go-creative-utils/storage"
+	"github.com/gin-gonic/gin"
+)
+
+var rolePermissions = map[string][]string{}
+
+// ConfigurePermissions maps every role to its permissions, named "<modelType>:<action>" like "invoice:read"
+// or "invoice:approve". "invoice:*" grants every action of a model and "*" grants everything.
+func ConfigurePermissions(permissions map[string][]string) {
+	rolePermissions = permissions
+	storage.ConfigurePermissionChecker(HasPermission)
+}
+
+func RoleHasPermission(role string, permission string) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == "*" || granted == permission {
+			return true
+		}
+		if resource, found := strings.CutSuffix(granted, ":*"); found && strings.HasPrefix(permission, resource+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission checks the role of the authenticated user
+func HasPermission(c *gin.Context, permission string) bool {
+	claims, ok := requestClaims(c)
+	return ok && RoleHasPermission(claims.GetRole(), permission)
+}
+
+// RequirePermission allows the request only if the user role has all the permissions
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := requestClaims(c)
+		if !ok {
+			apierrors.Abort(c, apierrors.New(apierrors.Unauthorized, "unauthorized"))
+			return
+		}
+		for _, permission := range permissions {
+			if !RoleHasPermission(claims.GetRole(), permission) {
+				apierrors.Abort(c, apierrors.New(apierrors.Forbidden, "forbidden").With("permission", permission))
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+func requestClaims(c *gin.Context) (shared.IdentityClaims, bool) {
+	user, exists := c.Get("user")
+	if !exists {
+		return nil, false
+	}
+	claims, ok := user.(shared.IdentityClaims)
+	return claims, ok
+}
@@ -0,0 +1,175 @@
+package security
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
+	"github.com/ahmedsaleh747/go-creative-utils/shared"
+	"github.com/ahmedsaleh747/go-creative-utils/storage"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// errRefreshRejected rolls the rotation back when the refresh token or its user isn't valid anymore
+var errRefreshRejected = errors.New("invalid refresh token")
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// Refresh exchanges a refresh token for a new access token, the refresh token is rotated on every call.
+// Presenting an already rotated token revokes every token issued from the same login.
+func Refresh(c *gin.Context, user storage.Identity, claims shared.IdentityClaims) {
+	var request refreshRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.RefreshToken == "" {
+		apierrors.Respond(c, apierrors.New(apierrors.BadRequest, "Invalid request"))
+		return
+	}
+	db, err := storage.GetDb(c)
+	if err != nil {
+		return
+	}
+
+	storedToken, err := storage.FindRefreshToken(db, hashRefreshToken(request.RefreshToken))
+	if err != nil || time.Now().After(storedToken.ExpiresAt) {
+		apierrors.Respond(c, apierrors.New(apierrors.Unauthorized, "Invalid refresh token"))
+		return
+	}
+	if storedToken.RevokedAt != nil {
+		revokeReusedToken(c, db, storedToken)
+		return
+	}
+
+	// The old refresh token is revoked together with issuing the new one, so a failure in between keeps
+	// the user logged in
+	var token, refreshToken string
+	reused := false
+	err = storage.WithTx(c, func(tx *gorm.DB) error {
+		rotated, err := storage.RevokeRefreshToken(tx, storedToken)
+		if err != nil {
+			return err
+		}
+		if reused = !rotated; reused {
+			return errRefreshRejected
+		}
+		if err := tx.First(user, storedToken.UserId).Error; err != nil {
+			return errRefreshRejected
+		}
+		claims.SetClaims(user)
+		token, refreshToken, err = newTokens(tx, claims, storedToken.FamilyId)
+		return err
+	})
+	switch {
+	case reused:
+		revokeReusedToken(c, db, storedToken)
+	case errors.Is(err, errRefreshRejected):
+		apierrors.Respond(c, apierrors.New(apierrors.Unauthorized, "Invalid refresh token"))
+	case err != nil:
+		log.Printf("Failed to rotate the refresh token of user %d: %v", storedToken.UserId, err)
+		apierrors.Respond(c, apierrors.New(apierrors.Internal, "Could not rotate refresh token"))
+	default:
+		c.JSON(http.StatusOK, gin.H{"token": token, "refreshToken": refreshToken})
+	}
+}
+
+// revokeReusedToken revokes every token issued from the same login as a refresh token presented again
+func revokeReusedToken(c *gin.Context, db *gorm.DB, storedToken *storage.RefreshToken) {
+	log.Printf("Refresh token reuse detected for user %d, revoking token family %s", storedToken.UserId, storedToken.FamilyId)
+	if err := storage.RevokeTokenFamily(db, storedToken.FamilyId); err != nil {
+		log.Printf("Failed to revoke token family %s: %v", storedToken.FamilyId, err)
+	}
+	apierrors.Respond(c, apierrors.New(apierrors.Unauthorized, "Invalid refresh token"))
+}
+
+// Logout blacklists the calling access token, and revokes the refresh token family when one is sent
+func Logout(c *gin.Context) {
+	claims, ok := requestClaims(c)
+	if !ok || claims.GetStandardClaims().Id == "" {
+		apierrors.Respond(c, apierrors.New(apierrors.Unauthorized, "unauthorized"))
+		return
+	}
+	db, err := storage.GetDb(c)
+	if err != nil {
+		return
+	}
+
+	standardClaims := claims.GetStandardClaims()
+	if err := storage.RevokeAccessToken(db, standardClaims.Id, time.Unix(standardClaims.ExpiresAt, 0)); err != nil {
+		apierrors.Respond(c, apierrors.New(apierrors.Internal, "Could not revoke token"))
+		return
+	}
+	var request refreshRequest
+	if err := c.ShouldBindJSON(&request); err == nil && request.RefreshToken != "" {
+		storedToken, err := storage.FindRefreshToken(db, hashRefreshToken(request.RefreshToken))
+		if err == nil && storedToken.UserId == claims.GetUserId() {
+			if err := storage.RevokeTokenFamily(db, storedToken.FamilyId); err != nil {
+				log.Printf("Failed to revoke token family %s: %v", storedToken.FamilyId, err)
+			}
+		}
+	}
+	if err := storage.PurgeExpiredTokens(db); err != nil {
+		log.Printf("Failed to purge expired tokens: %v", err)
+	}
+
+	log.Printf("Logout succedded for user: %s", claims.GetUsername())
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// RevokeUser revokes every refresh token of a user, e.g. while offboarding. The access tokens already
+// issued stay valid until they expire, which is bounded by the access token lifetime.
+func RevokeUser(userId uint) error {
+	return storage.RevokeUserRefreshTokens(storage.GetDbSpecial(), userId)
+}
+
+// issueTokens responds with a new access token and a refresh token of the given family, a new family is
+// started when it's empty
+func issueTokens(c *gin.Context, db *gorm.DB, claims shared.IdentityClaims, familyId string) {
+	token, refreshToken, err := newTokens(db, claims, familyId)
+	if err != nil {
+		log.Printf("Failed to issue the tokens of user %d: %v", claims.GetUserId(), err)
+		apierrors.Respond(c, apierrors.New(apierrors.Internal, "Could not generate token"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "refreshToken": refreshToken})
+}
+
+func newTokens(db *gorm.DB, claims shared.IdentityClaims, familyId string) (string, string, error) {
+	token, err := GenerateToken(claims)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := generateRefreshToken(db, claims.GetUserId(), familyId)
+	if err != nil {
+		return "", "", err
+	}
+	return token, refreshToken, nil
+}
+
+func generateRefreshToken(db *gorm.DB, userId uint, familyId string) (string, error) {
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	if familyId == "" {
+		if familyId, err = randomToken(16); err != nil {
+			return "", err
+		}
+	}
+	err = storage.SaveRefreshToken(db, &storage.RefreshToken{
+		TokenHash: hashRefreshToken(refreshToken),
+		FamilyId:  familyId,
+		UserId:    userId,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	})
+	return refreshToken, err
+}
+
+func hashRefreshToken(refreshToken string) string {
+	hash := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(hash[:])
+}
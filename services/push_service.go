@@ -0,0 +1,319 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ahmedsaleh747/go-creative-utils/shared"
+	"github.com/ahmedsaleh747/go-creative-utils/storage"
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/hkdf"
+	"gorm.io/gorm"
+)
+
+const pushRecordSize = 4096
+
+// PushService delivers notifications to the stored push subscriptions, following RFC 8291 for the payload
+// encryption and RFC 8292 (VAPID) to identify the application to the push services.
+type PushService struct {
+	vapidPublicKey  string
+	vapidPrivateKey *ecdsa.PrivateKey
+	subject         string
+
+	Client       *http.Client
+	Db           *gorm.DB
+	TTL          time.Duration
+	MaxRetries   int
+	RetryBackoff time.Duration
+}
+
+// NewPushService takes the VAPID keys as base64url strings, as generated by GenerateVapidKeys or the usual
+// web-push tools, and the contact subject (a mailto: or https: url)
+func NewPushService(vapidPublicKey string, vapidPrivateKey string, subject string) (*PushService, error) {
+	privateKey, publicKey, err := parseVapidPrivateKey(vapidPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(vapidPublicKey, "=") != publicKey {
+		return nil, errors.New("the VAPID public key doesn't match the private key")
+	}
+	return &PushService{
+		vapidPublicKey:  publicKey,
+		vapidPrivateKey: privateKey,
+		subject:         subject,
+		Client:          &http.Client{Timeout: 30 * time.Second},
+		TTL:             24 * time.Hour,
+		MaxRetries:      3,
+		RetryBackoff:    time.Second,
+	}, nil
+}
+
+func GenerateVapidKeys() (publicKey string, privateKey string, err error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	publicKey = base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes())
+	privateKey = base64.RawURLEncoding.EncodeToString(key.Bytes())
+	return
+}
+
+// VapidPublicKey is the applicationServerKey the browsers need to subscribe
+func (s *PushService) VapidPublicKey() string {
+	return s.vapidPublicKey
+}
+
+// SendToUser pushes the notification to every subscription of the user, subscriptions the push service
+// reports as gone are deleted
+func (s *PushService) SendToUser(ctx context.Context, userId uint, notification shared.Notification) error {
+	var subscriptions []storage.Subscription
+	if err := s.db().WithContext(ctx).Where("user_id = ?", userId).Find(&subscriptions).Error; err != nil {
+		return err
+	}
+	var errs []error
+	for i := range subscriptions {
+		if err := s.Send(ctx, &subscriptions[i], notification); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	log.Printf("Pushed notification to %d subscriptions of user %d, %d failed", len(subscriptions), userId, len(errs))
+	return errors.Join(errs...)
+}
+
+// Notify keeps the notification in the user inbox, then pushes it to the user subscriptions
+func (s *PushService) Notify(ctx context.Context, userId uint, notification shared.Notification) error {
+	if err := storage.AddInboxNotification(s.db().WithContext(ctx), userId, notification.Title, notification.Body, notification.Url); err != nil {
+		return err
+	}
+	return s.SendToUser(ctx, userId, notification)
+}
+
+// Send pushes the notification to the subscription, the retries stop waiting when ctx is done
+func (s *PushService) Send(ctx context.Context, subscription *storage.Subscription, notification shared.Notification) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	body, err := encryptPushPayload(subscription.P256dh, subscription.Auth, payload)
+	if err != nil {
+		return fmt.Errorf("subscription %d: %w", subscription.ID, err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		statusCode, retryAfter, err := s.post(ctx, subscription.Endpoint, body)
+		switch {
+		case err == nil && statusCode >= 200 && statusCode < 300:
+			return nil
+		case statusCode == http.StatusNotFound || statusCode == http.StatusGone:
+			log.Printf("Subscription %d expired, deleting it", subscription.ID)
+			return s.db().WithContext(ctx).Delete(subscription).Error
+		case err == nil && statusCode != http.StatusTooManyRequests && statusCode < 500:
+			return fmt.Errorf("subscription %d: push service rejected the notification with status %d", subscription.ID, statusCode)
+		case attempt >= s.MaxRetries:
+			if err == nil {
+				err = fmt.Errorf("push service responded with status %d", statusCode)
+			}
+			return fmt.Errorf("subscription %d: giving up after %d attempts: %w", subscription.ID, attempt+1, err)
+		}
+
+		// A Retry-After beyond the longest backoff isn't waited for, the caller gets the time to retry at
+		if maxBackoff := s.RetryBackoff << s.MaxRetries; retryAfter > maxBackoff {
+			return fmt.Errorf("subscription %d: push service asked to retry at %s", subscription.ID,
+				time.Now().Add(retryAfter).Format(time.RFC3339))
+		}
+		backoff := s.RetryBackoff << attempt
+		if retryAfter > backoff {
+			backoff = retryAfter
+		}
+		log.Printf("Push to subscription %d failed (status %d, error %v), retrying in %s", subscription.ID, statusCode, err, backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("subscription %d: %w", subscription.ID, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *PushService) post(ctx context.Context, endpoint string, body []byte) (statusCode int, retryAfter time.Duration, err error) {
+	authorization, err := s.vapidAuthorization(endpoint)
+	if err != nil {
+		return 0, 0, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	request.Header.Set("Authorization", authorization)
+	request.Header.Set("Content-Encoding", "aes128gcm")
+	request.Header.Set("Content-Type", "application/octet-stream")
+	request.Header.Set("TTL", strconv.Itoa(int(s.TTL.Seconds())))
+	request.Header.Set("Urgency", "normal")
+
+	response, err := s.Client.Do(request)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	// Retry-After is either a number of seconds or an HTTP date
+	if header := response.Header.Get("Retry-After"); header != "" {
+		if seconds, err := strconv.Atoi(header); err == nil {
+			retryAfter = time.Duration(seconds) * time.Second
+		} else if date, err := http.ParseTime(header); err == nil {
+			retryAfter = time.Until(date)
+		}
+	}
+	return response.StatusCode, retryAfter, nil
+}
+
+// vapidAuthorization signs the VAPID token for the origin of the push endpoint
+func (s *PushService) vapidAuthorization(endpoint string) (string, error) {
+	endpointUrl, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": endpointUrl.Scheme + "://" + endpointUrl.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": s.subject,
+	})
+	signedToken, err := token.SignedString(s.vapidPrivateKey)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("vapid t=%s, k=%s", signedToken, s.vapidPublicKey), nil
+}
+
+func (s *PushService) db() *gorm.DB {
+	if s.Db != nil {
+		return s.Db
+	}
+	return storage.GetDbSpecial()
+}
+
+// encryptPushPayload encrypts the payload for the subscription keys using the aes128gcm content encoding
+func encryptPushPayload(p256dh string, auth string, payload []byte) ([]byte, error) {
+	userAgentPublicBytes, err := decodePushKey(p256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	authSecret, err := decodePushKey(auth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth secret: %w", err)
+	}
+	userAgentPublicKey, err := ecdh.P256().NewPublicKey(userAgentPublicBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+
+	serverPrivateKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serverPublicBytes := serverPrivateKey.PublicKey().Bytes()
+	sharedSecret, err := serverPrivateKey.ECDH(userAgentPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), userAgentPublicBytes...)
+	keyInfo = append(keyInfo, serverPublicBytes...)
+	inputKey, err := hkdfExpand(sharedSecret, authSecret, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	contentKey, err := hkdfExpand(inputKey, salt, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdfExpand(inputKey, salt, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// A single record, terminated by the last record delimiter
+	plaintext := append(append([]byte{}, payload...), 0x02)
+
+	header := make([]byte, 0, 16+4+1+len(serverPublicBytes))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, pushRecordSize)
+	header = append(header, byte(len(serverPublicBytes)))
+	header = append(header, serverPublicBytes...)
+	body := gcm.Seal(header, nonce, plaintext, nil)
+	if len(body) > pushRecordSize {
+		return nil, fmt.Errorf("payload of %d bytes is too large for a push message", len(payload))
+	}
+	return body, nil
+}
+
+func hkdfExpand(secret []byte, salt []byte, info []byte, size int) ([]byte, error) {
+	key := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// decodePushKey accepts the keys as sent by the browsers, base64url with or without padding
+func decodePushKey(key string) ([]byte, error) {
+	key = strings.TrimRight(key, "=")
+	if decoded, err := base64.RawURLEncoding.DecodeString(key); err == nil {
+		return decoded, nil
+	}
+	return base64.RawStdEncoding.DecodeString(key)
+}
+
+// parseVapidPrivateKey returns the signing key with its base64url encoded public key
+func parseVapidPrivateKey(privateKey string) (*ecdsa.PrivateKey, string, error) {
+	privateBytes, err := decodePushKey(privateKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+	key, err := ecdh.P256().NewPrivateKey(privateBytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+	publicBytes := key.PublicKey().Bytes()
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(publicBytes[1:33]),
+			Y:     new(big.Int).SetBytes(publicBytes[33:]),
+		},
+		D: new(big.Int).SetBytes(privateBytes),
+	}, base64.RawURLEncoding.EncodeToString(publicBytes), nil
+}
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahmedsaleh747/go-creative-utils/shared"
+	"github.com/ahmedsaleh747/go-creative-utils/storage"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// recordingPool stands in for the database, it keeps the statements the push service executes
+type recordingPool struct {
+	mu         sync.Mutex
+	statements []string
+}
+
+func (p *recordingPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (p *recordingPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statements = append(p.statements, query)
+	return driverResult(1), nil
+}
+
+func (p *recordingPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func (p *recordingPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return nil
+}
+
+type driverResult int64
+
+func (r driverResult) LastInsertId() (int64, error) {
+	return 0, errors.New("not supported")
+}
+
+func (r driverResult) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
+
+// pushClient is the browser side of a subscription, it decrypts what the push service receives
+type pushClient struct {
+	key        *ecdh.PrivateKey
+	authSecret []byte
+}
+
+func newPushClient(t *testing.T) *pushClient {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSecret := make([]byte, 16)
+	if _, err := rand.Read(authSecret); err != nil {
+		t.Fatal(err)
+	}
+	return &pushClient{key: key, authSecret: authSecret}
+}
+
+func (client *pushClient) subscription(endpoint string) *storage.Subscription {
+	return &storage.Subscription{
+		ID:       7,
+		Endpoint: endpoint,
+		P256dh:   base64.RawURLEncoding.EncodeToString(client.key.PublicKey().Bytes()),
+		Auth:     base64.RawURLEncoding.EncodeToString(client.authSecret),
+	}
+}
+
+// decrypt reverses the aes128gcm content encoding of RFC 8291
+func (client *pushClient) decrypt(body []byte) ([]byte, error) {
+	if len(body) < 21 {
+		return nil, errors.New("body is too short")
+	}
+	salt := body[:16]
+	recordSize := binary.BigEndian.Uint32(body[16:20])
+	keyIdLength := int(body[20])
+	if len(body) < 21+keyIdLength || uint32(len(body)) > recordSize {
+		return nil, errors.New("invalid header")
+	}
+	serverPublicBytes := body[21 : 21+keyIdLength]
+	serverPublicKey, err := ecdh.P256().NewPublicKey(serverPublicBytes)
+	if err != nil {
+		return nil, err
+	}
+	sharedSecret, err := client.key.ECDH(serverPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), client.key.PublicKey().Bytes()...)
+	keyInfo = append(keyInfo, serverPublicBytes...)
+	inputKey, err := hkdfExpand(sharedSecret, client.authSecret, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	contentKey, err := hkdfExpand(inputKey, salt, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdfExpand(inputKey, salt, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, body[21+keyIdLength:], nil)
+	if err != nil {
+		return nil, err
+	}
+	// The padding is trimmed up to the delimiter of the last record
+	plaintext = bytes.TrimRight(plaintext, "\x00")
+	if len(plaintext) == 0 || plaintext[len(plaintext)-1] != 0x02 {
+		return nil, errors.New("missing last record delimiter")
+	}
+	return plaintext[:len(plaintext)-1], nil
+}
+
+// newTestPushService answers the push requests with the given handler, the statements run against the
+// database are recorded in the returned pool
+func newTestPushService(t *testing.T, handler http.HandlerFunc) (*PushService, *httptest.Server, *recordingPool) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	publicKey, privateKey, err := GenerateVapidKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewPushService(publicKey, privateKey, "mailto:admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := &recordingPool{}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: pool}), &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.Client = server.Client()
+	service.Db = db
+	service.RetryBackoff = time.Millisecond
+	return service, server, pool
+}
+
+func TestSendDeliversTheEncryptedNotification(t *testing.T) {
+	client := newPushClient(t)
+	notification := shared.Notification{Title: "Invoice paid", Body: "Invoice 42 was paid", Url: "/invoices/42"}
+	var received shared.Notification
+	service, server, _ := newTestPushService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "aes128gcm" {
+			t.Errorf("Content-Encoding = %q, want aes128gcm", r.Header.Get("Content-Encoding"))
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "vapid t=") {
+			t.Errorf("Authorization = %q, want a VAPID token", r.Header.Get("Authorization"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		payload, err := client.decrypt(body)
+		if err != nil {
+			t.Errorf("decrypting the payload: %v", err)
+		} else if err := json.Unmarshal(payload, &received); err != nil {
+			t.Errorf("decoding the payload: %v", err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	if err := service.Send(context.Background(), client.subscription(server.URL), notification); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+	if received != notification {
+		t.Errorf("received %+v, want %+v", received, notification)
+	}
+}
+
+func TestSendDeletesExpiredSubscriptions(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusGone} {
+		client := newPushClient(t)
+		service, server, pool := newTestPushService(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		})
+
+		if err := service.Send(context.Background(), client.subscription(server.URL), shared.Notification{Title: "Hello"}); err != nil {
+			t.Fatalf("status %d: Send() = %v", status, err)
+		}
+		if len(pool.statements) != 1 || !strings.HasPrefix(pool.statements[0], `DELETE FROM "subscriptions"`) {
+			t.Errorf("status %d: statements = %q, want the subscription deleted", status, pool.statements)
+		}
+	}
+}
+
+func TestSendRetriesTooManyRequests(t *testing.T) {
+	client := newPushClient(t)
+	attempts := 0
+	service, server, _ := newTestPushService(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	if err := service.Send(context.Background(), client.subscription(server.URL), shared.Notification{Title: "Hello"}); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+}
+
+func TestSendGivesUpOnLongRetryAfter(t *testing.T) {
+	client := newPushClient(t)
+	attempts := 0
+	service, server, _ := newTestPushService(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	err := service.Send(context.Background(), client.subscription(server.URL), shared.Notification{Title: "Hello"})
+	if err == nil || !strings.Contains(err.Error(), "retry at") {
+		t.Fatalf("Send() = %v, want the time to retry at", err)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestEncryptPushPayload(t *testing.T) {
+	client := newPushClient(t)
+	subscription := client.subscription("")
+	payload := []byte(`{"title":"Hello"}`)
+	// The browsers may send the keys padded
+	body, err := encryptPushPayload(subscription.P256dh+"=", subscription.Auth+"==", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := client.decrypt(body)
+	if err != nil {
+		t.Fatalf("decrypt() = %v", err)
+	}
+	if !bytes.Equal(decrypted, payload) {
+		t.Errorf("decrypted %s, want %s", decrypted, payload)
+	}
+
+	// Every message has its own salt and server key
+	other, err := encryptPushPayload(subscription.P256dh, subscription.Auth, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(body[:16], other[:16]) || bytes.Equal(body[21:86], other[21:86]) {
+		t.Error("the salt or the server key was reused")
+	}
+}
+
+func TestEncryptPushPayloadRejectsInvalidInput(t *testing.T) {
+	subscription := newPushClient(t).subscription("")
+	tests := []struct {
+		name    string
+		p256dh  string
+		auth    string
+		payload []byte
+	}{
+		{"malformed p256dh", "not a key!", subscription.Auth, []byte("{}")},
+		{"p256dh off the curve", base64.RawURLEncoding.EncodeToString(make([]byte, 65)), subscription.Auth, []byte("{}")},
+		{"malformed auth", subscription.P256dh, "not a secret!", []byte("{}")},
+		{"payload too large", subscription.P256dh, subscription.Auth, bytes.Repeat([]byte("a"), pushRecordSize)},
+	}
+	for _, test := range tests {
+		if _, err := encryptPushPayload(test.p256dh, test.auth, test.payload); err == nil {
+			t.Errorf("%s: encryptPushPayload() succeeded", test.name)
+		}
+	}
+}
+
+func TestSendStopsRetryingWhenTheContextIsDone(t *testing.T) {
+	client := newPushClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	service, server, _ := newTestPushService(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		cancel()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	service.RetryBackoff = time.Hour
+
+	err := service.Send(ctx, client.subscription(server.URL), shared.Notification{Title: "Hello"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Send() = %v, want the context error", err)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
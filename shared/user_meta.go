@@ -10,6 +10,7 @@ type IdentityClaims interface {
 	GetUserId() uint
 	GetUsername() string
 	GetRole() string
+	GetStandardClaims() jwt.StandardClaims
 	SetStandardClaims(jwt.StandardClaims)
 	SetClaims(storage.Identity)
 }
@@ -34,6 +35,10 @@ func (claims *UserMeta) GetRole() string {
 	return claims.Role
 }
 
+func (claims *UserMeta) GetStandardClaims() jwt.StandardClaims {
+	return claims.StandardClaims
+}
+
 func (claims *UserMeta) SetStandardClaims(standardClaims jwt.StandardClaims) {
 	claims.StandardClaims = standardClaims
 }
@@ -1,6 +1,9 @@
 // Mock configuration fetcher
 let config;
+let modelType;
 let pageSize = 20;
+let cursorPagination = true;    // Keyset pagination, set to false to page by offset
+let showTrash = false;          // Lists the soft deleted records of the model
 let filters = {};
 let sortFields = [];
 let dependencyConfigs = [];
@@ -8,12 +11,17 @@ let dependencyConfigs = [];
 async function loadConfiguration() {
     const path = window.location.pathname;
     const segments = path.split('/');
-    let modelType = null;
+    modelType = null;
     if (segments.length >= 3 && segments[1] === "model") {
         modelType = segments[2];
     }
     const response = await getModelConfig(modelType);
     config = response.data;
+    if (config.permissions?.create === false) $('#addRecordBtn').hide();
+    if (config.softDelete) addTrashToggle();
+    addBulkDeleteButton();
+    addExportButtons();
+    if (config.permissions?.create !== false) addImportButton();
 
     loadDependencies();
     generateTableHeader();
@@ -50,6 +58,17 @@ function generateTableHeader() {
     const tableHeaderFilterRow = $('<tr></tr>').attr("id", "filter-row")
     header.append(tableHeaderFilterRow)
 
+    const selectAll = $('<input>')
+        .attr('type', 'checkbox')
+        .attr('id', 'selectAllRecords')
+        .attr('class', 'form-check-input')
+        .change(function () {
+            $('.record-select').prop('checked', this.checked);
+            updateBulkActions();
+        });
+    tableHeaderRow.append($('<th></th>').css("width", "2%").append(selectAll));
+    tableHeaderFilterRow.append($('<th></th>'))
+
     config.fields.forEach(field => {
         const columnHeader = $('<th></th>')
             .attr('class', 'resizable')
@@ -79,29 +98,46 @@ async function fetchEntries(clear) {
     if (isLoading() || isLastPage()) return;
 
     const page = loadNextPage(loadingFlag);
-    const response = await secureFetch(`${config.apiUrl}?page=${page}&pageSize=${pageSize}`, {
+    const pageParams = cursorPagination ? {cursor: loadingFlag.data("cursor") ?? ''} : {page: page};
+    const response = await secureFetch(`${config.apiUrl}?pageSize=${pageSize}`, {
         headers: {'Content-Type': 'application/json'},
         data: {
+            ...pageParams,
+            ...(showTrash ? {onlyDeleted: true} : {}),
             ...flattenFilters(),
-            sort: sortFields,
+            sort: sortFields.join(','),
         }
     });
     const data = response.data.items?response.data.items:[];
     loadingFlag.hide();
     const body = $('#tableBody');
-    if (clear) body.empty();
+    if (clear) {
+        body.empty();
+        $('#selectAllRecords').prop('checked', false);
+        updateBulkActions();
+    }
 
+    if (cursorPagination) {
+        loadingFlag.data("cursor", response.data.nextCursor);
+        if (!response.data.nextCursor) loadingFlag.data("lastPage", true);
+    }
     if (data.length === 0) loadingFlag.data("lastPage", true);
     else {
         $('#totalCount').html(response.data.total)
-        $('#currentPage').html(response.data.currentPage)
-        $('#totalPages').html(response.data.totalPages)
+        $('#currentPage').html(response.data.currentPage ?? page)
+        $('#totalPages').html(response.data.totalPages ?? Math.ceil(response.data.total / pageSize))
     }
     $('#serverTime').html(displayFormattedDate(response.data.serverTime))
     data.forEach(modelRecord => {
         const modelRow = $('<tr></tr>')
         body.append(modelRow);
 
+        modelRow.append($('<td></td>').append($('<input>')
+            .attr('type', 'checkbox')
+            .attr('class', 'form-check-input record-select')
+            .data('id', modelRecord.id)
+            .change(updateBulkActions)));
+
         config.fields.forEach(field => {
             const fieldColumn = $('<td></td>');
             modelRow.append(fieldColumn);
@@ -111,16 +147,23 @@ async function fetchEntries(clear) {
 
         const modelActionsColumn = $('<td></td>')
         modelRow.append(modelActionsColumn);
+        if (showTrash) {
+            appendTrashActions(modelRecord, modelActionsColumn);
+            return;
+        }
 
         const editBtn = $('<button></button>')
             .attr('class', 'edit-btn')
             .data('id', modelRecord.id)
-            .click(function (e) {
+            .click(async function (e) {
                 const id = $(this).data('id');
                 const modelRow = $(e.target).closest('tr');
+                // Edit the latest version, its ETag guards the update against concurrent changes
+                const response = await secureFetch(`${config.apiUrl}/${id}`);
+                if (!response?.ok) return;
                 modelRow.empty();
 
-                appendModelRow(modelRecord, modelRow, `${config.apiUrl}/${id}`, 'PUT');
+                appendModelRow(response.data, modelRow, `${config.apiUrl}/${id}`, 'PUT', response.etag);
             });
         editBtn.append($('<img>')
             .attr("src", `https://cdn.jsdelivr.net/gh/ahmedsaleh747/go-creative-utils@v0.1.29/static/images/edit.png`)
@@ -142,8 +185,18 @@ async function fetchEntries(clear) {
             .attr("alt", "Delete")
             .attr("style", "width: 24px; height: 24px")
         )
-        modelActionsColumn.append(editBtn);
-        modelActionsColumn.append(deleteBtn);
+        if (config.permissions?.update !== false) modelActionsColumn.append(editBtn);
+        if (config.permissions?.delete !== false) modelActionsColumn.append(deleteBtn);
+        if (config.audit) {
+            const historyBtn = $('<button></button>')
+                .text("History")
+                .attr('class', 'history-btn btn btn-sm btn-outline-secondary')
+                .data('id', modelRecord.id)
+                .click(function () {
+                    showAuditHistory($(this).data('id'));
+                });
+            modelActionsColumn.append(historyBtn);
+        }
 
         if (config.actions != null)
             config.actions.forEach(action => {
@@ -165,6 +218,182 @@ async function fetchEntries(clear) {
     });
 }
 
+function addExportButtons() {
+    ['csv', 'xlsx'].forEach(format => {
+        const exportBtn = $('<button></button>')
+            .attr('class', 'btn btn-outline-secondary ms-2 export-btn')
+            .text(`Export ${format.toUpperCase()}`)
+            .click(function () {
+                // Exports every record matching the current filters and sort, not only the loaded pages
+                const params = buildQueryParams({
+                    format: format,
+                    ...(showTrash ? {onlyDeleted: true} : {}),
+                    ...flattenFilters(),
+                    sort: sortFields.join(','),
+                });
+                secureDownload(`${config.apiUrl}/export?${params}`, `${config.title}.${format}`);
+            });
+        $('#addRecordBtn').parent().append(exportBtn);
+    });
+}
+
+function addImportButton() {
+    const fileInput = $('<input>')
+        .attr('type', 'file')
+        .attr('accept', '.csv,text/csv')
+        .hide()
+        .change(async function () {
+            const file = this.files[0];
+            $(this).val('');
+            if (file == null) return;
+            // The dry run validates every row without saving anything
+            const response = await importFile(file, true);
+            if (response?.data != null) showImportPreview(file, response.data);
+        });
+    const importBtn = $('<button></button>')
+        .attr('id', 'importBtn')
+        .attr('class', 'btn btn-outline-secondary ms-2')
+        .text('Import CSV')
+        .click(() => fileInput.click());
+    $('#addRecordBtn').parent().append(importBtn, fileInput);
+}
+
+function importFile(file, dryRun) {
+    const formData = new FormData();
+    formData.append('file', file);
+    return secureFetch(`${config.apiUrl}/import?dryRun=${dryRun}`, {method: 'POST', body: formData});
+}
+
+function showImportPreview(file, report) {
+    $('#importPreview').remove();
+    const dialog = $('<div></div>')
+        .attr('id', 'importPreview')
+        .attr('class', 'modal fade')
+        .attr('tabindex', '-1');
+    const content = $('<div></div>').attr('class', 'modal-content');
+    dialog.append($('<div></div>').attr('class', 'modal-dialog modal-lg modal-dialog-scrollable').append(content));
+    content.append($('<div></div>')
+        .attr('class', 'modal-header')
+        .append($('<h5></h5>').attr('class', 'modal-title').text(`Import ${file.name}`))
+        .append($('<button></button>').attr('type', 'button').attr('class', 'btn-close').attr('data-bs-dismiss', 'modal')));
+
+    const body = $('<div></div>').attr('class', 'modal-body');
+    content.append(body);
+    if (report.error != null) body.append($('<p></p>').attr('class', 'text-danger').text(report.error));
+    if (report.total != null) {
+        body.append($('<p></p>').text(`${report.total - report.failed} of ${report.total} rows are ready to import`));
+    }
+    if (report.ignoredColumns?.length > 0) {
+        body.append($('<p></p>').attr('class', 'text-muted').text(`Ignored columns: ${report.ignoredColumns.join(', ')}`));
+    }
+    if (report.errors?.length > 0) {
+        const errorsTable = $('<table></table>').attr('class', 'table table-sm');
+        errorsTable.append('<thead><tr><th>Row</th><th>Field</th><th>Error</th></tr></thead>');
+        const errorsBody = $('<tbody></tbody>');
+        report.errors.forEach(importError => {
+            errorsBody.append($('<tr></tr>')
+                .append($('<td></td>').text(importError.row))
+                .append($('<td></td>').text(importError.field ?? ''))
+                .append($('<td></td>').text(importError.error)));
+        });
+        body.append(errorsTable.append(errorsBody));
+    }
+
+    const canImport = report.total > 0 && report.errors?.length === 0;
+    const importBtn = $('<button></button>')
+        .attr('type', 'button')
+        .attr('class', 'btn btn-primary')
+        .text('Import')
+        .prop('disabled', !canImport)
+        .click(async function () {
+            $(this).prop('disabled', true);
+            const response = await importFile(file, false);
+            bootstrap.Modal.getOrCreateInstance(dialog[0]).hide();
+            if (response?.ok) {
+                fetchEntries(true);
+            } else if (response?.data != null) {
+                showImportPreview(file, response.data);
+            }
+        });
+    content.append($('<div></div>')
+        .attr('class', 'modal-footer')
+        .append($('<button></button>').attr('type', 'button').attr('class', 'btn btn-secondary').attr('data-bs-dismiss', 'modal').text('Cancel'))
+        .append(importBtn));
+
+    $('body').append(dialog);
+    bootstrap.Modal.getOrCreateInstance(dialog[0]).show();
+}
+
+function addBulkDeleteButton() {
+    const bulkDeleteBtn = $('<button></button>')
+        .attr('id', 'bulkDeleteBtn')
+        .attr('class', 'btn btn-outline-danger ms-2')
+        .text('Delete selected')
+        .hide()
+        .click(async function () {
+            const ids = $('.record-select:checked').map((_, checkbox) => $(checkbox).data('id')).get();
+            if (ids.length === 0 || !confirm(`Are you sure you want to delete ${ids.length} records?`)) return;
+            const response = await secureFetch(`${config.apiUrl}/bulk?mode=bestEffort`, {
+                method: 'DELETE',
+                headers: {'Content-Type': 'application/json'},
+                body: JSON.stringify(ids)
+            });
+            if (response?.data?.failed > 0) {
+                const errors = response.data.items
+                    .filter(item => item.error)
+                    .map(item => `#${item.id}: ${item.error}`);
+                showToast('error', 'Some records were not deleted', errors.join('<br/>'), 10000);
+            }
+            fetchEntries(true);
+        });
+    $('#addRecordBtn').after(bulkDeleteBtn);
+}
+
+function updateBulkActions() {
+    const selected = $('.record-select:checked').length;
+    const canDelete = config.permissions?.delete !== false && !showTrash;
+    $('#bulkDeleteBtn').toggle(selected > 0 && canDelete).text(`Delete selected (${selected})`);
+}
+
+function addTrashToggle() {
+    const trashBtn = $('<button></button>')
+        .attr('id', 'trashBtn')
+        .attr('class', 'btn btn-outline-secondary ms-2')
+        .text('Trash')
+        .click(function () {
+            showTrash = !showTrash;
+            $(this).toggleClass('active', showTrash).text(showTrash ? 'Back to records' : 'Trash');
+            $('#addRecordBtn').toggle(!showTrash && config.permissions?.create !== false);
+            fetchEntries(true);
+        });
+    $('#addRecordBtn').after(trashBtn);
+}
+
+function appendTrashActions(modelRecord, modelActionsColumn) {
+    const restoreBtn = $('<button></button>')
+        .text("Restore")
+        .attr('class', 'restore-btn btn btn-sm btn-outline-primary')
+        .data('id', modelRecord.id)
+        .click(async function () {
+            const id = $(this).data('id');
+            const response = await secureFetch(`${config.apiUrl}/${id}/restore`, {method: 'POST'});
+            if (response?.ok) fetchEntries(true);
+        });
+    const purgeBtn = $('<button></button>')
+        .text("Delete forever")
+        .attr('class', 'purge-btn btn btn-sm btn-outline-danger')
+        .data('id', modelRecord.id)
+        .click(async function () {
+            if (confirm("This record will be deleted permanently, are you sure?")) {
+                const id = $(this).data('id');
+                const response = await secureFetch(`${config.apiUrl}/${id}/purge`, {method: 'DELETE'});
+                if (response?.ok) fetchEntries(true);
+            }
+        });
+    if (config.permissions?.update !== false) modelActionsColumn.append(restoreBtn);
+    if (config.permissions?.purge) modelActionsColumn.append(purgeBtn);
+}
+
 function addRecordRow() {
     const modelTableBody = $('.modelTable #tableBody');
     const modelRow = $('<tr></tr>');
@@ -173,8 +402,9 @@ function addRecordRow() {
     appendModelRow(null, modelRow, config.apiUrl, 'POST');
 }
 
-function appendModelRow(modelRecord, modelRow, apiUrl, apiMethod) {
+function appendModelRow(modelRecord, modelRow, apiUrl, apiMethod, etag) {
     const id = modelRecord == null ? '' : modelRecord['id'];
+    modelRow.append($('<td></td>'));
     config.fields.forEach(field => {
         appendFieldColumn(modelRecord, modelRow, field);
     });
@@ -186,6 +416,7 @@ function appendModelRow(modelRecord, modelRow, apiUrl, apiMethod) {
         .attr('class', 'save-btn btn btn-primary')
         .data('id', id)
         .click(async function () {
+            if (!validateRow(modelRow)) return;
             const record = {};
             const inputs = modelRow.find('.input-field')
             inputs.each(function (_, input) {
@@ -197,12 +428,22 @@ function appendModelRow(modelRecord, modelRow, apiUrl, apiMethod) {
                         ? CryptoJS.SHA256(input.val()).toString(CryptoJS.enc.Hex)
                         : input.val();
             });
+            const headers = {'Content-Type': 'application/json'};
+            if (etag) headers['If-Match'] = etag;
             const response = await secureFetch(apiUrl, {
                 method: apiMethod,
-                headers: {'Content-Type': 'application/json'},
+                headers: headers,
                 body: JSON.stringify(record)
             });
-            if (response.ok) fetchEntries(true);
+            if (response?.status === 409 && response.data.current) {
+                resolveConflict(record, response, modelRow, apiUrl, apiMethod);
+                return;
+            }
+            if (response?.status === 422 && response.data.fields) {
+                markInvalidFields(modelRow, response.data.fields);
+                return;
+            }
+            if (response?.ok) fetchEntries(true);
         });
     const cancelBtn = $('<button></button>')
         .text("Cancel")
@@ -217,6 +458,76 @@ function appendModelRow(modelRecord, modelRow, apiUrl, apiMethod) {
     modelActionsColumn.append(cancelBtn);
 }
 
+// validateRow checks the inputs against the validation rules of the config before they're sent
+function validateRow(modelRow) {
+    const invalidFields = {};
+    config.fields.forEach(field => {
+        const input = modelRow.find(`.input-field[name="${field.name}"]`);
+        if (field.validation == null || input.length === 0) return;
+        if (input.attr('type') === 'password' && input.val() === '****') return;
+        const message = validateValue(field, input.val() ?? '');
+        if (message != null) invalidFields[field.name] = message;
+    });
+    markInvalidFields(modelRow, invalidFields);
+    return Object.keys(invalidFields).length === 0;
+}
+
+function validateValue(field, value) {
+    const rules = field.validation;
+    if (value === '') return rules.required ? 'is required' : null;
+    if (field.type === 'number') {
+        const number = Number(value);
+        if (isNaN(number)) return 'must be a number';
+        if (rules.min != null && number < rules.min) return `must be at least ${rules.min}`;
+        if (rules.max != null && number > rules.max) return `must be at most ${rules.max}`;
+        return null;
+    }
+    const length = [...value].length;
+    if (rules.minLength != null && length < rules.minLength) return `must be at least ${rules.minLength} characters`;
+    if (rules.maxLength != null && length > rules.maxLength) return `must be at most ${rules.maxLength} characters`;
+    if (rules.pattern != null && !new RegExp(rules.pattern).test(value)) return 'has an invalid format';
+    if (rules.format === 'email' && !/^[^\s@]+@[^\s@]+$/.test(value)) return 'must be a valid email address';
+    if (rules.format === 'url' && !URL.canParse(value)) return 'must be a valid URL';
+    if (rules.oneOf != null && !rules.oneOf.includes(value)) return `must be one of ${rules.oneOf.join(', ')}`;
+    return null;
+}
+
+// markInvalidFields highlights the inputs of the row keyed by field name, the message shows as a tooltip
+function markInvalidFields(modelRow, invalidFields) {
+    modelRow.find('.input-field').each(function (_, input) {
+        input = $(input);
+        const message = invalidFields[input.attr('name')];
+        input.toggleClass('is-invalid', message != null);
+        input.closest('td')
+            .toggleClass('table-danger', message != null)
+            .attr('title', message == null ? null : `${config.fields.find(field => field.name === input.attr('name'))?.label ?? ''} ${message}`);
+    });
+}
+
+// resolveConflict shows the fields changed by someone else, then either overwrites them with the
+// edited values or reloads the row with the current server state
+async function resolveConflict(record, response, modelRow, apiUrl, apiMethod) {
+    const current = response.data.current;
+    const changes = config.fields
+        .filter(field => record[field.name] !== undefined && field.type !== 'password')
+        .filter(field => String(current[field.name] ?? '') !== String(record[field.name]))
+        .map(field => `${field.label}: "${current[field.name] ?? ''}" (yours: "${record[field.name]}")`);
+    const message = "This record was modified by someone else while you were editing it.\n\n" +
+        (changes.length > 0 ? `Current values:\n${changes.join('\n')}\n\n` : '') +
+        "Press OK to save your values anyway, or Cancel to load the current version.";
+    if (confirm(message)) {
+        const retry = await secureFetch(apiUrl, {
+            method: apiMethod,
+            headers: {'Content-Type': 'application/json', 'If-Match': response.etag},
+            body: JSON.stringify(record)
+        });
+        if (retry?.ok) fetchEntries(true);
+        return;
+    }
+    modelRow.empty();
+    appendModelRow(current, modelRow, apiUrl, apiMethod, response.etag);
+}
+
 function appendFieldColumn(modelRecord, modelRow, field) {
     const fieldColumn = $('<td></td>');
     modelRow.append(fieldColumn);
@@ -302,6 +613,60 @@ async function loadDependencies() {
     }
 }
 
+//---------------------------   AUDIT HISTORY START  ----------------------------------
+
+async function showAuditHistory(id) {
+    const response = await secureFetch(`/api/audit/${modelType}/${id}`);
+    if (!response?.ok) return;
+
+    $('#auditPanel').remove();
+    const panel = $('<div></div>')
+        .attr('id', 'auditPanel')
+        .attr('class', 'offcanvas offcanvas-end')
+        .attr('tabindex', '-1');
+    panel.append($('<div></div>')
+        .attr('class', 'offcanvas-header')
+        .append($('<h5></h5>').attr('class', 'offcanvas-title').text(`History of ${config.title} #${id}`))
+        .append($('<button></button>').attr('type', 'button').attr('class', 'btn-close').attr('data-bs-dismiss', 'offcanvas')));
+    const panelBody = $('<div></div>').attr('class', 'offcanvas-body');
+    panel.append(panelBody);
+
+    const entries = response.data.items ?? [];
+    if (entries.length === 0) panelBody.text('No history recorded');
+    entries.forEach(entry => {
+        const card = $('<div></div>').attr('class', 'card mb-2');
+        const cardBody = $('<div></div>').attr('class', 'card-body p-2');
+        card.append(cardBody);
+        cardBody.append($('<h6></h6>')
+            .attr('class', 'card-title')
+            .text(`${entry.action} by ${entry.username}`));
+        cardBody.append($('<small></small>')
+            .attr('class', 'text-muted')
+            .text(displayFormattedDate(entry.created_at)));
+
+        const changesList = $('<ul></ul>').attr('class', 'list-unstyled mb-0 mt-1');
+        Object.entries(entry.changes ?? {}).forEach(([fieldName, change]) => {
+            const field = config.fields.find(field => field.name === fieldName);
+            const label = field?.label ?? fieldName;
+            const from = change.from == null ? '' : field ? displayFormattedValue(field, change.from) : change.from;
+            const to = change.to == null ? '' : field ? displayFormattedValue(field, change.to) : change.to;
+            changesList.append($('<li></li>')
+                .append($('<strong></strong>').text(`${label}: `))
+                .append($('<span></span>').attr('class', 'text-decoration-line-through text-danger').text(from))
+                .append(' → ')
+                .append($('<span></span>').attr('class', 'text-success').text(to)));
+        });
+        cardBody.append(changesList);
+        panelBody.append(card);
+    });
+
+    $('body').append(panel);
+    bootstrap.Offcanvas.getOrCreateInstance(panel[0]).show();
+}
+
+//---------------------------   AUDIT HISTORY END  ----------------------------------
+
+
 //---------------------------   TABLE PAGINATION START  ----------------------------------
 
 function loadNextPage() {
@@ -315,6 +680,7 @@ function loadNextPage() {
 function clearLoading() {
     const loadingFlag = $('#loading')
     loadingFlag.data("page", 1)
+    loadingFlag.removeData("cursor")
     loadingFlag.removeData("lastPage")
 }
 
@@ -410,7 +776,7 @@ function displaySelectField(modelRecord, field, fieldColumn, editMode) {
 
 function displayTextField(modelRecord, field, fieldColumn, editMode) {
     const value = modelRecord == null ? '' : modelRecord[field.name];
-    if (editMode && field.name != 'created_at' && field.name != 'updated_at') {
+    if (editMode && !field.readonly && field.name != 'created_at' && field.name != 'updated_at') {
         const fieldInput = $('<input>')
             .attr('id', field.name)
             .attr('name', field.name)
@@ -640,11 +1006,11 @@ function prepareFilters() {
 
         // Update the class for the header based on the direction
         $(this).removeClass('asc desc');
-        sortFields = sortFields.filter(sort => !sort.startsWith(`${field} `)); // Remove existing sort for this field
+        sortFields = sortFields.filter(sort => sort.replace(/^-/, '') !== field); // Remove existing sort for this field
         if (direction) {
             $(this).addClass(direction);
             $(this).find('.sort-icon').text(direction === 'asc' ? '↑' : '↓');
-            sortFields.push(`${field} ${direction}`); // Add new sort if applicable
+            sortFields.push(direction === 'asc' ? field : `-${field}`); // Add new sort if applicable
         } else {
             $(this).find('.sort-icon').text(''); // Clear the sort icon
         }
@@ -1,3 +1,6 @@
+const refreshTokenUrl = '/refresh';
+const logoutUrl = '/logout';
+
 function isTokenExpired(token) {
     try {
         const payload = JSON.parse(atob(token.split('.')[1]));
@@ -9,12 +12,67 @@ function isTokenExpired(token) {
     }
 }
 
-function checkToken() {
-    const token = localStorage.getItem('token');
+function storeTokens(data) {
+    localStorage.setItem('token', data.token);
+    if (data.refreshToken) localStorage.setItem('refreshToken', data.refreshToken);
+}
+
+function clearTokens() {
+    localStorage.removeItem('token');
+    localStorage.removeItem('refreshToken');
+}
+
+let refreshInFlight = null;
+
+/**
+ * Exchanges the stored refresh token for a new access token, the concurrent callers share a single request
+ * since the refresh token rotates
+ * @returns true if the tokens were refreshed
+ */
+function refreshAccessToken() {
+    if (!refreshInFlight) {
+        refreshInFlight = doRefreshAccessToken().finally(() => refreshInFlight = null);
+    }
+    return refreshInFlight;
+}
+
+async function doRefreshAccessToken() {
+    const refreshToken = localStorage.getItem('refreshToken');
+    if (!refreshToken) return false;
+
+    const response = await fetch(refreshTokenUrl, {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({refreshToken: refreshToken})
+    }).catch(error => console.error("Failed to refresh token", error));
+    if (!response?.ok) {
+        localStorage.removeItem('refreshToken');
+        return false;
+    }
+    storeTokens(await response.json());
+    return true;
+}
+
+async function logout() {
+    await secureFetch(logoutUrl, {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({refreshToken: localStorage.getItem('refreshToken')})
+    });
+    clearTokens();
+    window.location.href = "/";
+}
+
+async function checkToken() {
+    let token = localStorage.getItem('token');
     const currentUrl = window.location.pathname ;
-    
+
+    if (token && isTokenExpired(token) && await refreshAccessToken()) {
+        console.log('Token is expired and has been refreshed');
+        token = localStorage.getItem('token');
+    }
     if (token && isTokenExpired(token)) {
-        localStorage.removeItem('token');
+        clearTokens();
         console.log('Token is expired and has been removed');
         if(currentUrl !== '/') window.location.href = "/";
     } else if (!token) {
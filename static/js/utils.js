@@ -1,5 +1,8 @@
 async function secureFetch(url, request, errorHandler) {
-    const token = localStorage.getItem('token');
+    let token = localStorage.getItem('token');
+    if (token && isTokenExpired(token) && await refreshAccessToken()) {
+        token = localStorage.getItem('token');
+    }
     if (!token) {
         redirectToLoginPage();
         return;
@@ -18,19 +21,30 @@ async function secureFetch(url, request, errorHandler) {
         request.headers['Authorization'] = 'Bearer ' + token;
     }
 
-    const response = await fetch(url, request).catch(errorHandler);
-    if (response?.status === 401 || response?.status === 403) {
-        localStorage.removeItem('token');
+    let response = await fetch(url, request).catch(errorHandler);
+    if (response?.status === 401 && await refreshAccessToken()) {
+        request.headers['Authorization'] = 'Bearer ' + localStorage.getItem('token');
+        response = await fetch(url, request).catch(errorHandler);
+    }
+    if (response?.status === 401) {
+        clearTokens();
         redirectToLoginPage();
         return
-    } else if (response?.status >= 500 && response?.status <= 599) {
-        showToast('error', 'Error!', 'Something went wrong! ' + response?.message, 10000);
     }
     // showToast('info', 'Information', 'Here is some important info.', 20000);
 
     let data;
     const contentType = response.headers.get('content-type');
-    if (contentType && contentType.includes('application/json')) {
+    const isProblem = contentType != null && contentType.includes('application/problem+json');
+    if (!isProblem && response?.status === 403) {
+        showToast('error', 'Forbidden!', 'You are not allowed to perform this operation', 10000);
+    } else if (!isProblem && response?.status >= 500 && response?.status <= 599) {
+        showToast('error', 'Error!', 'Something went wrong! ' + response?.message, 10000);
+    }
+    if (isProblem) {
+        data = await response.json();
+        showProblem(data);
+    } else if (contentType && contentType.includes('application/json')) {
         data = await response.json();
         if (data != null && data.action != undefined) {
             if (executeAction(data) ) {
@@ -48,10 +62,50 @@ async function secureFetch(url, request, errorHandler) {
 
     return {
         "ok": response.ok,
+        "status": response.status,
+        "etag": response.headers.get('ETag'),
         "data": data
     };
 }
 
+// secureDownload saves the response of an authenticated GET as a file, e.g. the exported records
+async function secureDownload(url, fileName) {
+    let token = localStorage.getItem('token');
+    if (token && isTokenExpired(token) && await refreshAccessToken()) {
+        token = localStorage.getItem('token');
+    }
+    if (!token) {
+        redirectToLoginPage();
+        return;
+    }
+    const response = await fetch(url, {headers: {'Authorization': 'Bearer ' + token}});
+    if (!response.ok) {
+        const problem = response.headers.get('content-type')?.includes('application/problem+json')
+            ? await response.json()
+            : {title: 'Error!', detail: 'The download failed'};
+        showProblem(problem);
+        return;
+    }
+    const disposition = response.headers.get('Content-Disposition');
+    const serverFileName = disposition?.match(/filename="([^"]+)"/)?.[1];
+    const link = document.createElement('a');
+    link.href = URL.createObjectURL(await response.blob());
+    link.download = serverFileName ?? fileName;
+    link.click();
+    URL.revokeObjectURL(link.href);
+}
+
+// showProblem renders an RFC 7807 error response, the conflicts carrying the current record are resolved
+// by the caller instead
+function showProblem(problem) {
+    if (problem?.current != null) return;
+    const title = problem?.status === 403 ? 'Forbidden!' : (problem?.title ?? 'Error!');
+    const detail = problem?.code === 'forbidden'
+        ? 'You are not allowed to perform this operation'
+        : (problem?.detail ?? 'Something went wrong!');
+    showToast('error', title, detail, 10000);
+}
+
 function redirectToLoginPage() {
     // Clear the current UI using jQuery
     const body = $('body');
@@ -176,6 +230,22 @@ async function showDialog(data) {
     });
 }
 
+/**
+ * Polls the unread notifications count into the nav bar badge, hidden when there are none
+ * @param {The badge element selector} badgeSelector
+ */
+function pollUnreadNotifications(badgeSelector = '#notificationsBadge', interval = 60000) {
+    const refreshBadge = async function () {
+        const response = await secureFetch('/api/inbox/unread-count');
+        if (!response?.ok) return;
+        const badge = $(badgeSelector);
+        badge.text(response.data.unread);
+        badge.toggle(response.data.unread > 0);
+    };
+    refreshBadge();
+    return setInterval(refreshBadge, interval);
+}
+
 function navigateTo(modelType) {
     window.location.href = `/model/${modelType}`;
 }
@@ -0,0 +1,322 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+const auditUserKey = "audit:user"
+
+var auditEnabled = true
+
+// AuditLog records who created, updated or deleted a record, with the before/after values of the changed fields
+type AuditLog struct {
+	ID        uint         `json:"id" gorm:"primaryKey"`
+	UserId    uint         `json:"user_id" gorm:"index"`
+	Username  string       `json:"username"`
+	Model     string       `json:"model" gorm:"index:idx_audit_logs_record"`
+	RecordId  string       `json:"record_id" gorm:"index:idx_audit_logs_record"`
+	Action    string       `json:"action"`
+	Changes   AuditChanges `json:"changes" gorm:"type:jsonb"`
+	CreatedAt time.Time    `json:"created_at" gorm:"autoCreateTime"`
+}
+
+func (*AuditLog) TableName() string {
+	return "audit_logs"
+}
+
+type AuditChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// AuditChanges maps the json name of every changed field to its values, stored as a jsonb column
+type AuditChanges map[string]AuditChange
+
+func (changes AuditChanges) Value() (driver.Value, error) {
+	value, err := json.Marshal(changes)
+	return string(value), err
+}
+
+func (changes *AuditChanges) Scan(value interface{}) error {
+	switch typedValue := value.(type) {
+	case []byte:
+		return json.Unmarshal(typedValue, changes)
+	case string:
+		return json.Unmarshal([]byte(typedValue), changes)
+	case nil:
+		*changes = nil
+		return nil
+	}
+	return fmt.Errorf("unsupported audit changes value %T", value)
+}
+
+// ConfigureAudit enables or disables the audit trail, it's enabled by default
+func ConfigureAudit(enabled bool) {
+	auditEnabled = enabled
+}
+
+// WithAuditUser attributes the changes written through the returned db to the user, the CRUD handlers
+// use the request user while the changes without a user are attributed to "system"
+func WithAuditUser(db *gorm.DB, user RequestUser) *gorm.DB {
+	return db.Set(auditUserKey, user).Session(&gorm.Session{})
+}
+
+func withRequestAuditUser(c *gin.Context, db *gorm.DB) *gorm.DB {
+	if user, ok := GetRequestUser(c); ok {
+		return WithAuditUser(db, user)
+	}
+	return db
+}
+
+func auditUser(db *gorm.DB) (uint, string) {
+	if value, ok := db.Get(auditUserKey); ok {
+		if user, ok := value.(RequestUser); ok {
+			return user.GetUserId(), user.GetUsername()
+		}
+	}
+	return 0, "system"
+}
+
+// audited runs the write and its audit entry in one transaction
+func audited(db *gorm.DB, write func(tx *gorm.DB) error) error {
+	if !auditEnabled {
+		return write(db)
+	}
+	return db.Transaction(write)
+}
+
+// loadAuditSnapshot reads the stored version of the record before it's overwritten, nil for a new record
+func loadAuditSnapshot(db *gorm.DB, record interface{}) (interface{}, error) {
+	if !auditEnabled {
+		return nil, nil
+	}
+	field, err := primaryField(db, record)
+	if err != nil {
+		return nil, err
+	}
+	id, isZero := field.ValueOf(db.Statement.Context, reflect.ValueOf(record).Elem())
+	if isZero {
+		return nil, nil
+	}
+	snapshot := reflect.New(reflect.TypeOf(record).Elem()).Interface()
+	err = db.Session(&gorm.Session{NewDB: true}).
+		Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: id}).
+		Take(snapshot).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return snapshot, err
+}
+
+// writeAudit stores the audit entry of the change, updates that didn't change any field aren't recorded
+func writeAudit(db *gorm.DB, action string, before interface{}, after interface{}) error {
+	if !auditEnabled {
+		return nil
+	}
+	record := after
+	if record == nil {
+		record = before
+	}
+	field, err := primaryField(db, record)
+	if err != nil {
+		return err
+	}
+	id, _ := field.ValueOf(db.Statement.Context, reflect.ValueOf(record).Elem())
+	changes, err := auditChanges(db, before, after)
+	if err != nil {
+		return err
+	}
+	if action == AuditUpdate && len(changes) == 0 {
+		return nil
+	}
+
+	userId, username := auditUser(db)
+	entry := &AuditLog{
+		UserId:   userId,
+		Username: username,
+		Model:    strings.ToLower(reflect.TypeOf(record).Elem().Name()),
+		RecordId: fmt.Sprint(auditValue(id)),
+		Action:   action,
+		Changes:  changes,
+	}
+	return db.Session(&gorm.Session{NewDB: true}).Create(entry).Error
+}
+
+// auditChanges diffs the columns of the two versions, either may be nil, sensitive fields are masked
+func auditChanges(db *gorm.DB, before interface{}, after interface{}) (AuditChanges, error) {
+	record := after
+	if record == nil {
+		record = before
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(record); err != nil {
+		return nil, err
+	}
+	ctx := db.Statement.Context
+	changes := AuditChanges{}
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		if before != nil && after != nil && (field.AutoCreateTime > 0 || field.AutoUpdateTime > 0) {
+			continue
+		}
+		var from, to interface{}
+		if before != nil {
+			value, isZero := field.ValueOf(ctx, reflect.ValueOf(before).Elem())
+			if !isZero {
+				from = auditValue(value)
+			}
+		}
+		if after != nil {
+			value, isZero := field.ValueOf(ctx, reflect.ValueOf(after).Elem())
+			if !isZero {
+				to = auditValue(value)
+			}
+		}
+		if auditEqual(from, to) {
+			continue
+		}
+		if strings.Contains(field.Tag.Get("extras"), "sensitive") {
+			from, to = maskAuditValue(from), maskAuditValue(to)
+		}
+		changes[auditFieldName(field)] = AuditChange{From: from, To: to}
+	}
+	return changes, nil
+}
+
+func auditValue(value interface{}) interface{} {
+	reflectValue := reflect.ValueOf(value)
+	for reflectValue.Kind() == reflect.Pointer {
+		if reflectValue.IsNil() {
+			return nil
+		}
+		reflectValue = reflectValue.Elem()
+	}
+	if !reflectValue.IsValid() {
+		return nil
+	}
+	return reflectValue.Interface()
+}
+
+func auditEqual(from interface{}, to interface{}) bool {
+	if fromTime, ok := from.(time.Time); ok {
+		toTime, ok := to.(time.Time)
+		return ok && fromTime.Equal(toTime)
+	}
+	return reflect.DeepEqual(from, to)
+}
+
+func maskAuditValue(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	return maskedPassword
+}
+
+func auditFieldName(field *schema.Field) string {
+	if name := strings.Split(field.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+		return name
+	}
+	return field.DBName
+}
+
+// GetAuditHistory lists the audit entries of a record in the model Scope, it needs "<modelType>:read"
+func GetAuditHistory(c *gin.Context) {
+	modelType := strings.ToLower(c.Param("modelType"))
+	id := c.Param("id")
+	if !hasPermission(c, modelType+":read") {
+		apierrors.Respond(c, apierrors.New(apierrors.Forbidden, "forbidden"))
+		return
+	}
+	db, err := GetDb(c)
+	if err != nil {
+		return
+	}
+	model, err := registryOf(c).newModel(modelType)
+	if err != nil {
+		apierrors.Respond(c, apierrors.New(apierrors.NotFound, "Model not found!"))
+		return
+	}
+	if _, ok := model.(Scoper); ok && !isRecordInScope(c, db, model, id) {
+		apierrors.Respond(c, gorm.ErrRecordNotFound)
+		return
+	}
+
+	var entries []AuditLog
+	if err := db.Where("model = ? AND record_id = ?", modelType, id).Order("created_at desc, id desc").Find(&entries).Error; err != nil {
+		apierrors.Respond(c, err)
+		return
+	}
+	config := filterModelConfig(c, modelType, registryOf(c).config(modelType))
+	for i := range entries {
+		entries[i].Changes = visibleAuditChanges(db, model, entries[i].Changes, config)
+	}
+	log.Printf("Found %d audit entries for %s %s", len(entries), modelType, id)
+	c.JSON(http.StatusOK, gin.H{"items": entries})
+}
+
+// visibleAuditChanges keeps the changes of the fields the caller sees in the model config, the hidden fields
+// and the ones behind a permission the caller lacks are left out
+func visibleAuditChanges(db *gorm.DB, model interface{}, changes AuditChanges, config map[string]any) AuditChanges {
+	visible := AuditChanges{}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return visible
+	}
+	fields, _ := config["fields"].([]map[string]any)
+	for _, configField := range fields {
+		name, _ := configField["name"].(string)
+		field := configSchemaField(stmt.Schema, reflect.TypeOf(model).Elem(), name)
+		if field == nil {
+			continue
+		}
+		if change, ok := changes[auditFieldName(field)]; ok {
+			visible[auditFieldName(field)] = change
+		}
+	}
+	return visible
+}
+
+// configSchemaField finds the field of a config name, the json name of a field or the foreign key of a select field
+func configSchemaField(modelSchema *schema.Schema, modelType reflect.Type, name string) *schema.Field {
+	if structField, ok := findModelField(modelType, name); ok {
+		name = structField.Name
+	}
+	return modelSchema.LookUpField(name)
+}
+
+func isRecordInScope(c *gin.Context, db *gorm.DB, model interface{}, id string) bool {
+	field, err := primaryField(db, model)
+	if err != nil {
+		return false
+	}
+	record := reflect.New(reflect.TypeOf(model).Elem()).Interface()
+	var count int64
+	err = applyScope(c, db, record).Unscoped().Model(record).
+		Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: id}).
+		Count(&count).Error
+	return err == nil && count > 0
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+type auditTestCustomer struct {
+	ID   uint   `json:"id" gorm:"primaryKey"`
+	Name string `json:"name"`
+}
+
+type auditTestOrder struct {
+	ID         uint              `json:"id" gorm:"primaryKey" extras:"hidden"`
+	Number     string            `json:"number"`
+	CustomerId uint              `json:"customer_id" extras:"hidden"`
+	Customer   auditTestCustomer `json:"customer" gorm:"foreignKey:CustomerId"`
+	Note       string            `json:"-" gorm:"column:remark"`
+	Secret     string            `json:"secret" extras:"hidden"`
+}
+
+func TestVisibleAuditChanges(t *testing.T) {
+	config := map[string]any{"fields": extractModelFields(reflect.TypeOf(auditTestOrder{}))}
+	changes := AuditChanges{
+		"number":      {From: "A-1", To: "A-2"},
+		"customer_id": {From: 1, To: 2},
+		"remark":      {From: nil, To: "urgent"},
+		"secret":      {From: "a", To: "b"},
+	}
+
+	visible := visibleAuditChanges(newTestDb(t), &auditTestOrder{}, changes, config)
+	want := AuditChanges{
+		"number":      changes["number"],
+		"customer_id": changes["customer_id"],
+		"remark":      changes["remark"],
+	}
+	if !reflect.DeepEqual(visible, want) {
+		t.Errorf("visibleAuditChanges() = %v, want %v", visible, want)
+	}
+}
@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+)
+
+const maxBulkItems = 1000
+
+const (
+	bulkAtomic     = "atomic"
+	bulkBestEffort = "bestEffort"
+)
+
+// BulkItemResult is the outcome of one item of a bulk request, in the order of the request
+type BulkItemResult struct {
+	Index  int               `json:"index"`
+	Id     interface{}       `json:"id,omitempty"`
+	Status int               `json:"status"`
+	Code   apierrors.Code    `json:"code,omitempty"`
+	Error  string            `json:"error,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+	Record interface{}       `json:"record,omitempty"`
+}
+
+// BulkCreateRecords creates the records of the JSON array body
+func BulkCreateRecords[R Model](c *gin.Context, _ *[]R) {
+	var items []json.RawMessage
+	if !readBulkBody(c, &items) {
+		return
+	}
+	runBulk(c, len(items), func(tx *gorm.DB, index int) BulkItemResult {
+		record := new(R)
+		if err := binding.JSON.BindBody(items[index], record); err != nil {
+			return bulkError(index, nil, bindingError(record, err))
+		}
+		if err := createModelRecord(withRequestAuditUser(c, tx), record); err != nil {
+			return bulkError(index, nil, err)
+		}
+		runPostLoad(tx, record)
+		return BulkItemResult{Index: index, Id: recordId(tx, record), Status: http.StatusOK, Record: record}
+	})
+}
+
+// BulkUpdateRecords updates the records of the JSON array body by their id, and version for versioned models
+func BulkUpdateRecords[R Model](c *gin.Context, _ *[]R) {
+	var items []json.RawMessage
+	if !readBulkBody(c, &items) {
+		return
+	}
+	runBulk(c, len(items), func(tx *gorm.DB, index int) BulkItemResult {
+		item := new(R)
+		if err := json.Unmarshal(items[index], item); err != nil {
+			return bulkError(index, nil, err)
+		}
+		id := recordId(tx, item)
+		if id == nil {
+			return bulkError(index, nil, errors.New("id is required"))
+		}
+		record := new(R)
+		if err := getLockedRecordById(c, tx, record, fmt.Sprint(id)); err != nil {
+			return bulkError(index, id, gorm.ErrRecordNotFound)
+		}
+		if err := matchVersion(tx, record, bodyVersion(tx, item)); err != nil {
+			return bulkError(index, id, err)
+		}
+		stored := *record
+		if err := binding.JSON.BindBody(items[index], record); err != nil {
+			return bulkError(index, id, bindingError(record, err))
+		}
+		if err := keepPrimaryKey(tx, record, &stored); err != nil {
+			return bulkError(index, id, err)
+		}
+		if err := keepVersion(tx, record, &stored); err != nil {
+			return bulkError(index, id, err)
+		}
+		if err := persistRecord(withRequestAuditUser(c, tx), record); err != nil {
+			return bulkError(index, id, err)
+		}
+		runPostLoad(tx, record)
+		return BulkItemResult{Index: index, Id: id, Status: http.StatusOK, Record: record}
+	})
+}
+
+// BulkDeleteRecords deletes the records of the JSON array of ids
+func BulkDeleteRecords[R Model](c *gin.Context, _ *[]R) {
+	var ids []interface{}
+	if !readBulkBody(c, &ids) {
+		return
+	}
+	runBulk(c, len(ids), func(tx *gorm.DB, index int) BulkItemResult {
+		id, err := bulkId(tx, new(R), ids[index])
+		if err != nil {
+			return bulkError(index, ids[index], err)
+		}
+		if err := deleteRecordById(c, tx, new(R), id); err != nil {
+			return bulkError(index, ids[index], err)
+		}
+		return BulkItemResult{Index: index, Id: ids[index], Status: http.StatusOK}
+	})
+}
+
+// bulkId checks an id of the body against the type of the primary key
+func bulkId(db *gorm.DB, record interface{}, value interface{}) (string, error) {
+	primaryKey, err := primaryField(db, record)
+	if err != nil {
+		return "", err
+	}
+	var id string
+	switch value := value.(type) {
+	case json.Number:
+		id = value.String()
+	case string:
+		id = value
+	default:
+		return "", apierrors.Newf(apierrors.BadRequest, "the id %v must be a number or a string", value)
+	}
+	if isNumberKind(primaryKey.FieldType) {
+		if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+			return "", apierrors.Newf(apierrors.BadRequest, "the id %q must be a positive integer", id)
+		}
+	} else if _, isNumber := value.(json.Number); isNumber {
+		return "", apierrors.Newf(apierrors.BadRequest, "the id %s must be a string", id)
+	}
+	return id, nil
+}
+
+func readBulkBody(c *gin.Context, target interface{}) bool {
+	body, err := c.GetRawData()
+	if err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		err = decoder.Decode(target)
+	}
+	if err != nil {
+		apierrors.Respond(c, apierrors.New(apierrors.BadRequest, "the body must be a JSON array: "+err.Error()))
+		return false
+	}
+	return true
+}
+
+// runBulk runs every item in the request transaction, mode=bestEffort rolls back only the failed items
+func runBulk(c *gin.Context, count int, run func(tx *gorm.DB, index int) BulkItemResult) {
+	mode := c.DefaultQuery("mode", bulkAtomic)
+	if mode != bulkAtomic && mode != bulkBestEffort {
+		apierrors.Respond(c, apierrors.Newf(apierrors.BadRequest, "mode must be %s or %s", bulkAtomic, bulkBestEffort).WithField("mode"))
+		return
+	}
+	if count == 0 || count > maxBulkItems {
+		apierrors.Respond(c, apierrors.Newf(apierrors.BadRequest, "a bulk request takes 1 to %d items", maxBulkItems))
+		return
+	}
+	tx, err := GetTx(c)
+	if err != nil {
+		return
+	}
+
+	results := make([]BulkItemResult, 0, count)
+	failed := 0
+	for index := 0; index < count; index++ {
+		savepoint := fmt.Sprintf("bulk_item_%d", index)
+		if mode == bulkBestEffort {
+			if err := tx.SavePoint(savepoint).Error; err != nil {
+				c.Error(err)
+				apierrors.Respond(c, err)
+				return
+			}
+		}
+		result := run(tx, index)
+		results = append(results, result)
+		if result.Error == "" {
+			continue
+		}
+		failed++
+		if mode == bulkAtomic {
+			// The TransactionMiddleware rolls back once the error is recorded
+			c.Error(errors.New(result.Error))
+			apierrors.Respond(c, apierrors.Newf(result.Code, "item %d failed, nothing was saved: %s", index, result.Error).
+				With("mode", mode).
+				With("items", results))
+			return
+		}
+		if err := tx.RollbackTo(savepoint).Error; err != nil {
+			c.Error(err)
+			apierrors.Respond(c, err)
+			return
+		}
+	}
+
+	log.Printf("Bulk request of %d items done, %d failed", count, failed)
+	c.JSON(http.StatusOK, gin.H{
+		"action":    "Toast",
+		"message":   fmt.Sprintf("%d of %d records processed", count-failed, count),
+		"mode":      mode,
+		"succeeded": count - failed,
+		"failed":    failed,
+		"items":     results,
+	})
+}
+
+func bulkError(index int, id interface{}, err error) BulkItemResult {
+	apiErr := apiError(err, apierrors.BadRequest)
+	fields, _ := apiErr.Extras["fields"].(map[string]string)
+	return BulkItemResult{Index: index, Id: id, Status: apiErr.Status, Code: apiErr.Code, Error: apiErr.Detail, Fields: fields}
+}
+
+// recordId is the primary key of the record, nil when it's not set
+func recordId(db *gorm.DB, record interface{}) interface{} {
+	field, err := primaryField(db, record)
+	if err != nil {
+		return nil
+	}
+	id, isZero := field.ValueOf(db.Statement.Context, reflect.ValueOf(record).Elem())
+	if isZero {
+		return nil
+	}
+	return auditValue(id)
+}
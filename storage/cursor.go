@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// listCursor is the position after the last returned record, it carries the sort it was issued for
+// and the sort key values of that record with the primary key as the last value
+type listCursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+}
+
+func encodeCursor(cursor listCursor) (string, error) {
+	cursorJson, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(cursorJson), nil
+}
+
+func decodeCursor(rawCursor string) (*listCursor, error) {
+	cursorJson, err := base64.RawURLEncoding.DecodeString(rawCursor)
+	if err != nil {
+		return nil, &QueryError{Param: "cursor", Reason: "cursor is malformed"}
+	}
+	var cursor listCursor
+	decoder := json.NewDecoder(bytes.NewReader(cursorJson))
+	decoder.UseNumber()
+	if err := decoder.Decode(&cursor); err != nil {
+		return nil, &QueryError{Param: "cursor", Reason: "cursor is malformed"}
+	}
+	return &cursor, nil
+}
+
+// getModelRecordsAfter loads the page following the cursor, empty for the first page, without an offset
+func getModelRecordsAfter[R Model](q *listQuery, query string, pageSize int, rawCursor string, withCount bool, records *[]R, modelTypes []string) (count int64, nextCursor string, err error) {
+	if pageSize < 1 {
+		pageSize = 20
+	}
+	var nilRecord *R = nil
+	primaryKey, err := primaryField(q.db, nilRecord)
+	if err != nil {
+		return
+	}
+
+	q.db = prepareModelQuery(q.db, query, records, modelTypes)
+	if len(q.sorts) == 0 {
+		q.applyDefaultSort(preFetchSort(q.db, (*R)(nil)))
+	}
+	db := q.db.Order(stableOrder())
+	sortSpec := q.sortSpec()
+
+	if withCount {
+		if err = db.Model(nilRecord).Count(&count).Error; err != nil {
+			return
+		}
+	}
+	if rawCursor != "" {
+		cursor, cursorErr := decodeCursor(rawCursor)
+		if cursorErr != nil {
+			return 0, "", cursorErr
+		}
+		if cursor.Sort != sortSpec || len(cursor.Values) != len(q.sorts)+1 {
+			return 0, "", &QueryError{Param: "cursor", Reason: "cursor does not match the requested sort"}
+		}
+		condition, args := q.keysetCondition(primaryKey.DBName, cursor.Values)
+		db = db.Where(condition, args...)
+	}
+
+	if err = db.Limit(pageSize + 1).Find(records).Error; err != nil {
+		return
+	}
+	log.Printf("Found %d records after cursor", len(*records))
+	if len(*records) <= pageSize {
+		return
+	}
+
+	*records = (*records)[:pageSize]
+	lastRecord := reflect.ValueOf(&(*records)[pageSize-1]).Elem()
+	lastId, _ := primaryKey.ValueOf(q.db.Statement.Context, lastRecord)
+	values, err := q.sortValues(primaryKey.DBName, lastId)
+	if err != nil {
+		return
+	}
+	nextCursor, err = encodeCursor(listCursor{Sort: sortSpec, Values: values})
+	return
+}
+
+// applyDefaultSort uses the model PreFetchSort as keyset sort when it's expressed in the sort grammar
+func (q *listQuery) applyDefaultSort(defaultSort string) {
+	if defaultSort == "" {
+		return
+	}
+	// A new session, the conditions applied so far may have left q.db mutable in place
+	db := q.db.Session(&gorm.Session{})
+	q.db = db
+	if err := q.applySort(defaultSort); err != nil {
+		log.Printf("PreFetchSort %q can't be used for cursor pagination, sorting by primary key: %v", defaultSort, err)
+		q.db = db
+		q.sorts = nil
+	}
+}
+
+func (q *listQuery) sortSpec() string {
+	var terms []string
+	for _, term := range q.sorts {
+		if term.desc {
+			terms = append(terms, "-"+term.field)
+		} else {
+			terms = append(terms, term.field)
+		}
+	}
+	return strings.Join(terms, ",")
+}
+
+// keysetCondition builds (k1 > v1) OR (k1 = v1 AND k2 > v2) ..., the NULLs sorting last in both directions
+func (q *listQuery) keysetCondition(primaryKey string, values []interface{}) (string, []interface{}) {
+	columns := q.sortColumns(primaryKey)
+	var conditions []string
+	var args []interface{}
+	for i := range columns {
+		if values[i] == nil {
+			continue
+		}
+		var parts []string
+		for j := 0; j < i; j++ {
+			if values[j] == nil {
+				parts = append(parts, columns[j]+" IS NULL")
+				continue
+			}
+			parts = append(parts, columns[j]+" = ?")
+			args = append(args, values[j])
+		}
+		operator := ">"
+		if i < len(q.sorts) && q.sorts[i].desc {
+			operator = "<"
+		}
+		if i < len(q.sorts) {
+			parts = append(parts, "("+columns[i]+" "+operator+" ? OR "+columns[i]+" IS NULL)")
+		} else {
+			parts = append(parts, columns[i]+" "+operator+" ?")
+		}
+		args = append(args, values[i])
+		conditions = append(conditions, "("+strings.Join(parts, " AND ")+")")
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// sortValues reads the sort keys of a record, including the ones coming from joined models
+func (q *listQuery) sortValues(primaryKey string, id interface{}) ([]interface{}, error) {
+	if len(q.sorts) == 0 {
+		return []interface{}{id}, nil
+	}
+	columns := q.sortColumns(primaryKey)
+	db := q.db.Session(&gorm.Session{NewDB: true}).Table(q.tableName)
+	for _, joinSql := range q.joinSql {
+		db = db.Joins(joinSql)
+	}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	row := db.Select(strings.Join(columns, ", ")).Where(q.tableName+"."+primaryKey+" = ?", id).Row()
+	if err := row.Scan(pointers...); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (q *listQuery) sortColumns(primaryKey string) []string {
+	var columns []string
+	for _, term := range q.sorts {
+		columns = append(columns, term.column.Table+"."+term.column.Name)
+	}
+	return append(columns, q.tableName+"."+primaryKey)
+}
+
+func primaryField(db *gorm.DB, model interface{}) (*schema.Field, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	if stmt.Schema.PrioritizedPrimaryField == nil {
+		return nil, fmt.Errorf("model %s has no primary key", stmt.Schema.Name)
+	}
+	return stmt.Schema.PrioritizedPrimaryField, nil
+}
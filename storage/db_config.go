@@ -3,8 +3,10 @@ package storage
 import (
 	"errors"
 	"log"
-	"net/http"
+	"reflect"
+	"strings"
 
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -27,46 +29,84 @@ func InitDatabaseModels(dsn string, models []interface{}) {
 		log.Fatalf("failed to migrate database: %v\n", err)
 		return
 	}
+	if err := db.AutoMigrate(&RefreshToken{}, &RevokedToken{}, &InboxNotification{}, &AuditLog{}); err != nil {
+		log.Fatalf("failed to migrate module tables: %v\n", err)
+		return
+	}
 
-	models = append(models, &User{})
-	models = append(models, &Subscription{})
-	for _, model := range models {
+	for _, model := range withBuiltinModels(models) {
 		AddConfig(model)
 	}
 }
 
+// withBuiltinModels adds the models of the module to the app ones, except the ones the app replaces with a
+// model of the same name or table, e.g. its own User, which would otherwise collide in the registry
+func withBuiltinModels(models []interface{}) []interface{} {
+	names, tables := map[string]bool{}, map[string]bool{}
+	for _, model := range models {
+		names[strings.ToLower(reflect.TypeOf(model).Elem().Name())] = true
+		if table := schemaTable(model); table != "" {
+			tables[table] = true
+		}
+	}
+	for _, builtin := range []interface{}{&User{}, &Subscription{}, &InboxNotification{}} {
+		name := strings.ToLower(reflect.TypeOf(builtin).Elem().Name())
+		if names[name] || tables[schemaTable(builtin)] {
+			log.Printf("Model %s is replaced by the app one, skipping the built-in model", name)
+			continue
+		}
+		models = append(models, builtin)
+	}
+	return models
+}
+
+func schemaTable(model interface{}) string {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return ""
+	}
+	return stmt.Schema.Table
+}
+
 func DBMiddleware() gin.HandlerFunc {
     return func(c *gin.Context) {
         // Add a scoped DB instance to the context
-        UpdateDb(c, db.Session(&gorm.Session{}))
+        UpdateDb(c, db.WithContext(c.Request.Context()))
         c.Next()
     }
 }
 
+// TransactionMiddleware runs the request in a transaction, which GetDb returns to the handlers. It's rolled
+// back when a handler panics, records an error in the context or answers with a status other than 2xx. The
+// response is held back until the commit, a failed commit is answered with a problem instead, unless the
+// handler flushed a streamed response already.
 func TransactionMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Start a transaction
-		tx := db.Begin()
-		if tx.Error != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
-			c.Abort()
+		writer := newTxResponseWriter(c.Writer)
+		c.Writer = writer
+		defer func() {
+			c.Writer = writer.ResponseWriter
+		}()
+		err := db.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+			UpdateTx(c, tx.WithContext(ContextWithDb(c.Request.Context(), tx)))
+			c.Next()
+			if status := c.Writer.Status(); len(c.Errors) > 0 || status < 200 || status >= 300 {
+				return errRollback
+			}
+			return nil
+		})
+		if err == nil || errors.Is(err, errRollback) {
+			writer.flush()
 			return
 		}
-
-		// Add transaction to context
-		UpdateTx(c, tx)
-
-		// Process request
-		c.Next()
-
-		// Commit or rollback based on errors
-		if len(c.Errors) > 0 {
-			tx.Rollback()
-		} else {
-			if err := tx.Commit().Error; err != nil {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
-			}
+		// The transaction couldn't begin or commit
+		if writer.flushed {
+			log.Printf("%s %s failed to commit: %v", c.Request.Method, c.Request.URL.Path, err)
+			return
 		}
+		writer.discard()
+		apierrors.Abort(c, apierrors.From(err, apierrors.DbUnavailable))
+		writer.flush()
 	}
 }
 
@@ -74,12 +114,18 @@ func GetDbSpecial() (*gorm.DB) {
     return db
 }
 
-// GetTx retrieves the scoped *gorm.DB instance from the Gin context.
+// GetDb retrieves the scoped *gorm.DB instance from the Gin context, the transaction of the
+// TransactionMiddleware when there's one.
 func GetDb(c *gin.Context) (*gorm.DB, error) {
+	if tx, exists := c.Get("tx"); exists {
+		if gormTx, ok := tx.(*gorm.DB); ok {
+			return gormTx, nil
+		}
+	}
 	db, exists := c.Get("db")
 	if !exists {
 	    errorStr := "Database connection not found in context"
-        c.JSON(http.StatusInternalServerError, gin.H{"error": errorStr})
+        apierrors.Respond(c, apierrors.New(apierrors.Internal, errorStr))
 		return nil, errors.New(errorStr)
 	}
 
@@ -87,7 +133,7 @@ func GetDb(c *gin.Context) (*gorm.DB, error) {
 	gormDb, ok := db.(*gorm.DB)
 	if !ok {
 	    errorStr := "Context value is not a *gorm.DB instance"
-        c.JSON(http.StatusInternalServerError, gin.H{"error": errorStr})
+        apierrors.Respond(c, apierrors.New(apierrors.Internal, errorStr))
 		return nil, errors.New(errorStr)
 	}
 
@@ -99,7 +145,7 @@ func GetTx(c *gin.Context) (*gorm.DB, error) {
 	tx, exists := c.Get("tx")
 	if !exists {
 	    errorStr := "Transaction not found in context"
-        c.JSON(http.StatusInternalServerError, gin.H{"error": errorStr})
+        apierrors.Respond(c, apierrors.New(apierrors.Internal, errorStr))
 		return nil, errors.New(errorStr)
 	}
 
@@ -107,7 +153,7 @@ func GetTx(c *gin.Context) (*gorm.DB, error) {
 	gormTx, ok := tx.(*gorm.DB)
 	if !ok {
 	    errorStr := "Context value is not a *gorm.DB instance"
-        c.JSON(http.StatusInternalServerError, gin.H{"error": errorStr})
+        apierrors.Respond(c, apierrors.New(apierrors.Internal, errorStr))
 		return nil, errors.New(errorStr)
 	}
 
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
+	"github.com/gin-gonic/gin"
+)
+
+// apiError gives the errors of this package their code and extension members, other errors are classified
+// by apierrors.From with the fallback code
+func apiError(err error, fallback apierrors.Code) *apierrors.Error {
+	var validationErr *ValidationError
+	var conflict *versionConflict
+	var patchErr *PatchError
+	var queryErr *QueryError
+	switch {
+	case errors.As(err, &validationErr):
+		return apierrors.Wrap(apierrors.Validation, err).With("fields", validationErr.Fields)
+	case errors.Is(err, errPreconditionRequired):
+		return apierrors.Wrap(apierrors.PreconditionRequired, err)
+	case errors.As(err, &conflict):
+		return apierrors.Wrap(apierrors.Conflict, err)
+	case errors.As(err, &patchErr):
+		code := apierrors.BadRequest
+		if patchErr.status == apierrors.StatusOf(apierrors.Conflict) {
+			code = apierrors.Conflict
+		}
+		return apierrors.Wrap(code, err).WithField(patchErr.Path).With("details", patchErr)
+	case errors.As(err, &queryErr):
+		return apierrors.Wrap(apierrors.BadRequest, err).WithField(queryErr.Field).With("details", queryErr)
+	}
+	return apierrors.From(err, fallback)
+}
+
+// respondError renders err as a problem, fallback is the code of the errors that aren't classified
+func respondError(c *gin.Context, err error, fallback apierrors.Code) {
+	apierrors.Respond(c, apiError(err, fallback))
+}
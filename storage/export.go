@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const exportBatchSize = 500
+
+const exportDateLayout = "2006-01-02 15:04"
+
+// exportCell is a formatted value, numbers are kept as numbers in the XLSX sheets
+type exportCell struct {
+	value  string
+	number bool
+}
+
+type exportWriter interface {
+	WriteRow(cells []exportCell) error
+	Flush() error
+	Close() error
+}
+
+func ExportRecords[R Model](c *gin.Context, records *[]R) {
+	ExportModelRecords(c, records, []string{})
+}
+
+// ExportModelRecords streams every record matching the filters and sort of GetModelRecords as CSV or XLSX
+func ExportModelRecords[R Model](c *gin.Context, records *[]R, modelTypes []string) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "xlsx" {
+		apierrors.Respond(c, apierrors.New(apierrors.BadRequest, "format must be csv or xlsx").WithField("format"))
+		return
+	}
+	query := c.DefaultQuery("query", "")
+	db, err := GetDb(c)
+	if err != nil {
+		return
+	}
+	listQuery, err := newRecordsQuery(c, db, records)
+	if err != nil {
+		respondError(c, err, apierrors.Internal)
+		return
+	}
+	modelType := listQuery.modelType.Name()
+	config := filterModelConfig(c, modelType, listQuery.registry.config(modelType))
+	configFields, ok := config["fields"].([]map[string]any)
+	if !ok {
+		apierrors.Respond(c, apierrors.New(apierrors.NotFound, "Model not found!"))
+		return
+	}
+	var fields []map[string]any
+	for _, field := range configFields {
+		if configFieldType(field) != "password" {
+			fields = append(fields, field)
+		}
+	}
+
+	listQuery.db = prepareModelQuery(listQuery.db, query, records, modelTypes)
+	if len(listQuery.sorts) == 0 {
+		listQuery.applyDefaultSort(preFetchSort(listQuery.db, (*R)(nil)))
+	}
+	db = listQuery.db.Order(stableOrder()).Session(&gorm.Session{})
+
+	fileName := strings.ToLower(modelType) + "-" + time.Now().Format("20060102-150405") + "." + format
+	c.Header("Content-Disposition", `attachment; filename="`+fileName+`"`)
+	var writer exportWriter
+	if format == "xlsx" {
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		title, _ := config["title"].(string)
+		writer, err = newXlsxWriter(c.Writer, title)
+	} else {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		writer = &csvExportWriter{writer: csv.NewWriter(c.Writer), flusher: c.Writer}
+	}
+	if err != nil {
+		apierrors.Respond(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+
+	// The response is already streaming, failures can only be logged and end the file early
+	if err := writeExport(listQuery, db, writer, fields, records); err != nil {
+		log.Printf("Export of %s failed: %v", modelType, err)
+	}
+	if err := writer.Close(); err != nil {
+		log.Printf("Export of %s failed: %v", modelType, err)
+	}
+}
+
+// writeExport pages through the records with the keyset condition of the cursor pagination
+func writeExport[R Model](q *listQuery, db *gorm.DB, writer exportWriter, fields []map[string]any, records *[]R) error {
+	primaryKey, err := primaryField(db, (*R)(nil))
+	if err != nil {
+		return err
+	}
+	header := make([]exportCell, len(fields))
+	for i, field := range fields {
+		header[i] = exportCell{value: fmt.Sprint(field["label"])}
+	}
+	if err := writer.WriteRow(header); err != nil {
+		return err
+	}
+
+	count := 0
+	var after []interface{}
+	for {
+		batch := db
+		if after != nil {
+			condition, args := q.keysetCondition(primaryKey.DBName, after)
+			batch = db.Where(condition, args...)
+		}
+		*records = (*records)[:0]
+		if err := batch.Limit(exportBatchSize).Find(records).Error; err != nil {
+			return err
+		}
+		rows, err := exportRows(db, q.registry, fields, records)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := writer.WriteRow(row); err != nil {
+				return err
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		count += len(*records)
+		if len(*records) < exportBatchSize {
+			log.Printf("Exported %d records", count)
+			return nil
+		}
+		lastRecord := reflect.ValueOf(&(*records)[len(*records)-1]).Elem()
+		lastId, _ := primaryKey.ValueOf(db.Statement.Context, lastRecord)
+		if after, err = q.sortValues(primaryKey.DBName, lastId); err != nil {
+			return err
+		}
+	}
+}
+
+// exportRows formats a batch of records, select fields are resolved to the name of the related records
+func exportRows[R Model](db *gorm.DB, registry *Registry, fields []map[string]any, records *[]R) ([][]exportCell, error) {
+	var values []map[string]any
+	for i := range *records {
+		runPostLoad(db, &(*records)[i])
+		recordJson, err := json.Marshal(&(*records)[i])
+		if err != nil {
+			return nil, err
+		}
+		var recordValues map[string]any
+		decoder := json.NewDecoder(bytes.NewReader(recordJson))
+		decoder.UseNumber()
+		if err := decoder.Decode(&recordValues); err != nil {
+			return nil, err
+		}
+		values = append(values, recordValues)
+	}
+
+	names := map[string]map[string]string{}
+	for _, field := range fields {
+		if !isSelectorField(field) {
+			continue
+		}
+		fieldName := field["name"].(string)
+		var ids []any
+		for _, recordValues := range values {
+			if id, ok := recordValues[fieldName].(json.Number); ok {
+				if intId, err := id.Int64(); err == nil {
+					ids = append(ids, intId)
+				}
+			} else if id, ok := recordValues[fieldName].(string); ok && id != "" {
+				ids = append(ids, id)
+			}
+		}
+		fieldNames, err := relatedNames(db, registry, field["selectorOf"].(string), ids)
+		if err != nil {
+			return nil, err
+		}
+		names[fieldName] = fieldNames
+	}
+
+	rows := make([][]exportCell, len(values))
+	for i, recordValues := range values {
+		row := make([]exportCell, len(fields))
+		for j, field := range fields {
+			fieldName := field["name"].(string)
+			row[j] = formatExportValue(field, recordValues[fieldName], names[fieldName])
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+// relatedNames maps the primary keys to the name column of the selector model
+func relatedNames(db *gorm.DB, registry *Registry, selectorOf string, ids []any) (map[string]string, error) {
+	names := map[string]string{}
+	if len(ids) == 0 {
+		return names, nil
+	}
+	selectorModel, err := registry.newModel(selectorOf)
+	if err != nil {
+		return nil, err
+	}
+	key, err := primaryField(db, selectorModel)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Session(&gorm.Session{NewDB: true}).Table(modelTableName(selectorModel)).
+		Select([]string{key.DBName, "name"}).Where(clause.IN{Column: clause.Column{Name: key.DBName}, Values: ids}).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id any
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[fmt.Sprint(id)] = name
+	}
+	return names, rows.Err()
+}
+
+func formatExportValue(field map[string]any, value any, names map[string]string) exportCell {
+	if value == nil {
+		return exportCell{}
+	}
+	if isSelectorField(field) {
+		return exportCell{value: names[fmt.Sprint(value)]}
+	}
+	switch configFieldType(field) {
+	case "date":
+		if date, err := time.Parse(time.RFC3339Nano, fmt.Sprint(value)); err == nil {
+			if date.IsZero() {
+				return exportCell{}
+			}
+			return exportCell{value: date.UTC().Format(exportDateLayout)}
+		}
+	case "bool":
+		if value == true {
+			return exportCell{value: "Yes"}
+		}
+		return exportCell{value: "No"}
+	case "number":
+		if number, ok := value.(json.Number); ok {
+			return exportCell{value: number.String(), number: true}
+		}
+	}
+	switch typedValue := value.(type) {
+	case string:
+		return exportCell{value: typedValue}
+	case json.Number, bool:
+		return exportCell{value: fmt.Sprint(typedValue)}
+	}
+	encoded, _ := json.Marshal(value)
+	return exportCell{value: string(encoded)}
+}
+
+type csvExportWriter struct {
+	writer  *csv.Writer
+	flusher http.Flusher
+}
+
+func (w *csvExportWriter) WriteRow(cells []exportCell) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		record[i] = cell.value
+		// Keeps spreadsheets from evaluating exported text as a formula
+		if !cell.number && cell.value != "" && strings.ContainsRune("=+-@", rune(cell.value[0])) {
+			record[i] = "'" + cell.value
+		}
+	}
+	return w.writer.Write(record)
+}
+
+func (w *csvExportWriter) Flush() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		return err
+	}
+	w.flusher.Flush()
+	return nil
+}
+
+func (w *csvExportWriter) Close() error {
+	return w.Flush()
+}
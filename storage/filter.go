@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// QueryError describes a list query parameter that was rejected before reaching the database.
+type QueryError struct {
+	Param    string `json:"param"`
+	Field    string `json:"field,omitempty"`
+	Operator string `json:"operator,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("invalid %s parameter: %s", e.Param, e.Reason)
+}
+
+var textOperators = []string{"blank", "notBlank", "equals", "notEquals", "contains", "notContains", "in"}
+var rangeOperators = []string{"=", ">", ">=", "<", "<=", "between"}
+
+// Operators allowed per config field type, password fields can't be filtered at all
+var filterOperators = map[string][]string{
+	"text":   textOperators,
+	"select": textOperators,
+	"number": rangeOperators,
+	"date":   rangeOperators,
+	"bool":   {"="},
+}
+
+var dateLayouts = []string{"2006-01-02", "2006-01-02T15:04", time.RFC3339}
+
+// listQuery compiles the list query parameters of a model into gorm conditions
+type listQuery struct {
+	db        *gorm.DB
+	registry  *Registry
+	modelType reflect.Type
+	tableName string
+	fields    []map[string]any
+	joins     map[string]string
+	joinSql   []string
+	sorts     []sortTerm
+}
+
+func newListQuery(db *gorm.DB, registry *Registry, modelType reflect.Type, tableName string, fields []map[string]any) *listQuery {
+	return &listQuery{
+		db:        db,
+		registry:  registry,
+		modelType: modelType,
+		tableName: tableName,
+		fields:    fields,
+		joins:     map[string]string{},
+	}
+}
+
+func (q *listQuery) applyFilters(c *gin.Context) error {
+	for _, field := range q.fields {
+		fieldName := field["name"].(string)
+		filterOperator := getFilterValue(c, fieldName+"-operator")
+		if len(filterOperator) == 0 {
+			continue
+		}
+		filterValue := getFilterValue(c, fieldName+"-value")
+		filterValue2 := getFilterValue(c, fieldName+"-value2")
+		if err := q.applyFilter(field, filterOperator, filterValue, filterValue2); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *listQuery) applyFilter(field map[string]any, filterOperator string, filterValue string, filterValue2 string) error {
+	fieldName := field["name"].(string)
+	fieldType := configFieldType(field)
+	if !slices.Contains(filterOperators[fieldType], filterOperator) {
+		return &QueryError{
+			Param:    fieldName + "-operator",
+			Field:    fieldName,
+			Operator: filterOperator,
+			Reason:   fmt.Sprintf("operator is not supported for %s fields", fieldType),
+		}
+	}
+
+	column := q.tableName + "." + q.columnName(fieldName)
+	if isSelectorField(field) {
+		alias, err := q.joinSelector(field)
+		if err != nil {
+			return err
+		}
+		column = alias + ".name"
+	}
+
+	if fieldType == "number" || fieldType == "bool" || fieldType == "date" {
+		return q.applyRangeFilter(field, column, filterOperator, filterValue, filterValue2)
+	}
+	return q.applyTextFilter(field, column, filterOperator, filterValue)
+}
+
+func (q *listQuery) applyRangeFilter(field map[string]any, column string, filterOperator string, filterValue string, filterValue2 string) error {
+	fieldName := field["name"].(string)
+	value, err := q.parseFilterValue(field, fieldName+"-value", filterValue)
+	if err != nil {
+		return err
+	}
+	if configFieldType(field) == "date" {
+		column += "::date"
+	}
+
+	if filterOperator == "between" {
+		value2, err := q.parseFilterValue(field, fieldName+"-value2", filterValue2)
+		if err != nil {
+			return err
+		}
+		q.db = q.db.Where(column+" BETWEEN ? AND ?", value, value2)
+		return nil
+	}
+	// The operator is one of the whitelisted comparison operators
+	q.db = q.db.Where(column+" "+filterOperator+" ?", value)
+	return nil
+}
+
+func (q *listQuery) applyTextFilter(field map[string]any, column string, filterOperator string, filterValue string) error {
+	fieldName := field["name"].(string)
+	if filterOperator != "blank" && filterOperator != "notBlank" && filterValue == "" {
+		return &QueryError{Param: fieldName + "-value", Field: fieldName, Operator: filterOperator, Reason: "value is required"}
+	}
+	if allowedValues, ok := field["allowedValues"].([]string); ok && (filterOperator == "equals" || filterOperator == "notEquals") {
+		if !slices.Contains(allowedValues, filterValue) {
+			return &QueryError{Param: fieldName + "-value", Field: fieldName, Value: filterValue, Reason: "value is not one of the allowed values"}
+		}
+	}
+
+	switch filterOperator {
+	case "blank":
+		q.db = q.db.Where(fmt.Sprintf("(%s IS NULL OR %s = '')", column, column))
+	case "notBlank":
+		q.db = q.db.Where(fmt.Sprintf("(%s IS NOT NULL AND NOT %s = '')", column, column))
+	case "equals":
+		q.db = q.db.Where(column+" = ?", filterValue)
+	case "notEquals":
+		q.db = q.db.Where("NOT "+column+" = ?", filterValue)
+	case "contains":
+		q.db = q.db.Where(column+" ILIKE ?", "%"+escapeLike(filterValue)+"%")
+	case "notContains":
+		q.db = q.db.Where("NOT "+column+" ILIKE ?", "%"+escapeLike(filterValue)+"%")
+	case "in":
+		var conditions []string
+		var args []interface{}
+		for _, val := range strings.Split(filterValue, ",") {
+			if val = strings.TrimSpace(val); val != "" {
+				conditions = append(conditions, column+" ILIKE ?")
+				args = append(args, "%"+escapeLike(val)+"%")
+			}
+		}
+		if len(conditions) == 0 {
+			return &QueryError{Param: fieldName + "-value", Field: fieldName, Operator: filterOperator, Reason: "value is required"}
+		}
+		q.db = q.db.Where("("+strings.Join(conditions, " OR ")+")", args...)
+	}
+	return nil
+}
+
+func (q *listQuery) parseFilterValue(field map[string]any, param string, rawValue string) (interface{}, error) {
+	fieldName := field["name"].(string)
+	if rawValue == "" {
+		return nil, &QueryError{Param: param, Field: fieldName, Reason: "value is required"}
+	}
+	goType := reflect.TypeOf("")
+	if structField, ok := findModelField(q.modelType, fieldName); ok {
+		goType = structField.Type
+	}
+	value, err := parseFieldValue(configFieldType(field), goType, rawValue)
+	if err != nil {
+		return nil, &QueryError{Param: param, Field: fieldName, Value: rawValue, Reason: err.Error()}
+	}
+	if date, ok := value.(time.Time); ok {
+		return date.Format("2006-01-02"), nil
+	}
+	return value, nil
+}
+
+// columnName maps the json name of a model field to its column, e.g. a Name field serialized as "username"
+// is stored in the name column
+func (q *listQuery) columnName(fieldName string) string {
+	structField, ok := findModelField(q.modelType, fieldName)
+	if !ok {
+		return fieldName
+	}
+	stmt := &gorm.Statement{DB: q.db}
+	if err := stmt.Parse(reflect.New(q.modelType).Interface()); err != nil {
+		return fieldName
+	}
+	if field := stmt.Schema.LookUpField(structField.Name); field != nil && field.DBName != "" {
+		return field.DBName
+	}
+	return fieldName
+}
+
+// joinSelector joins the model a select field points to, once per field, and returns its alias
+func (q *listQuery) joinSelector(field map[string]any) (string, error) {
+	fieldName := field["name"].(string)
+	if alias, ok := q.joins[fieldName]; ok {
+		return alias, nil
+	}
+	selectorModel, err := q.registry.newModel(field["selectorOf"].(string))
+	if err != nil {
+		return "", err
+	}
+	selectorTableName := modelTableName(selectorModel)
+	alias := fieldName + "_" + selectorTableName
+	joinSql := fmt.Sprintf("left join %s as %s on %s.id = %s.%s",
+		selectorTableName, alias, alias, q.tableName, q.columnName(fieldName))
+	q.db = q.db.Joins(joinSql)
+	q.joins[fieldName] = alias
+	q.joinSql = append(q.joinSql, joinSql)
+	return alias, nil
+}
+
+// parseFieldValue converts a raw request value into the Go value of a model field
+func parseFieldValue(fieldType string, goType reflect.Type, rawValue string) (interface{}, error) {
+	for goType.Kind() == reflect.Ptr {
+		goType = goType.Elem()
+	}
+	switch fieldType {
+	case "number":
+		switch goType.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			value, err := strconv.ParseInt(rawValue, 10, goType.Bits())
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a valid integer", rawValue)
+			}
+			return value, nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			value, err := strconv.ParseUint(rawValue, 10, goType.Bits())
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a valid positive integer", rawValue)
+			}
+			return value, nil
+		default:
+			value, err := strconv.ParseFloat(rawValue, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a valid number", rawValue)
+			}
+			return value, nil
+		}
+	case "bool":
+		value, err := strconv.ParseBool(rawValue)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid boolean", rawValue)
+		}
+		return value, nil
+	case "date":
+		for _, layout := range dateLayouts {
+			if value, err := time.Parse(layout, rawValue); err == nil {
+				return value, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not a valid date", rawValue)
+	}
+	return rawValue, nil
+}
+
+// findModelField looks up the struct field serialized under the given json name
+func findModelField(modelType reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < modelType.NumField(); i++ {
+		field := modelType.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if embeddedField, ok := findModelField(field.Type, name); ok {
+				embeddedField.Index = append([]int{i}, embeddedField.Index...)
+				return embeddedField, true
+			}
+			continue
+		}
+		fieldName := strings.Split(field.Tag.Get("json"), ",")[0]
+		if fieldName == "-" || fieldName == "" {
+			fieldName = strings.ToLower(field.Name)
+		}
+		if fieldName == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func configFieldType(field map[string]any) string {
+	if fieldType, ok := field["type"].(string); ok {
+		return fieldType
+	}
+	return "text"
+}
+
+func isSelectorField(field map[string]any) bool {
+	selectorOf, ok := field["selectorOf"].(string)
+	return ok && selectorOf != "enum"
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+type filterTestItem struct {
+	ID     uint      `json:"id" gorm:"primaryKey"`
+	Title  string    `json:"title" gorm:"column:name"`
+	Price  float64   `json:"price"`
+	Count  int       `json:"count"`
+	Born   time.Time `json:"born"`
+	Secret string    `json:"secret"`
+}
+
+func (*filterTestItem) TableName() string {
+	return "items"
+}
+
+var filterTestFields = []map[string]any{
+	{"name": "title"},
+	{"name": "price", "type": "number"},
+	{"name": "count", "type": "number"},
+	{"name": "born", "type": "date"},
+	{"name": "secret", "type": "password"},
+}
+
+func filterTestField(name string) map[string]any {
+	for _, field := range filterTestFields {
+		if field["name"] == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// noConnPool makes gorm build the statements without a database, they're only generated in dry run mode
+type noConnPool struct{}
+
+func (noConnPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, errors.New("no database")
+}
+
+func (noConnPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return nil, errors.New("no database")
+}
+
+func (noConnPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, errors.New("no database")
+}
+
+func (noConnPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return nil
+}
+
+func newTestDb(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: noConnPool{}}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		Logger:               logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestListQuery(t *testing.T) *listQuery {
+	return newListQuery(newTestDb(t), NewRegistry(), reflect.TypeOf(filterTestItem{}), "items", filterTestFields)
+}
+
+// querySql is the statement the list query compiles to, with its bound values
+func querySql(q *listQuery) (string, []interface{}) {
+	var items []filterTestItem
+	stmt := q.db.Find(&items).Statement
+	return stmt.SQL.String(), stmt.Vars
+}
+
+func TestParseSortTerm(t *testing.T) {
+	tests := []struct {
+		term  string
+		field string
+		desc  bool
+		ok    bool
+	}{
+		{"name", "name", false, true},
+		{"-name", "name", true, true},
+		{"+name", "name", false, true},
+		{"name:asc", "name", false, true},
+		{"name:DESC", "name", true, true},
+		{"name desc", "name", true, true},
+		{"name:sideways", "name", false, false},
+		{"name up", "name", false, false},
+	}
+	for _, test := range tests {
+		field, desc, ok := parseSortTerm(test.term)
+		if field != test.field || desc != test.desc || ok != test.ok {
+			t.Errorf("parseSortTerm(%q) = %q, %v, %v, want %q, %v, %v", test.term, field, desc, ok, test.field, test.desc, test.ok)
+		}
+	}
+}
+
+func TestParseFieldValue(t *testing.T) {
+	tests := []struct {
+		fieldType string
+		goType    reflect.Type
+		raw       string
+		want      interface{}
+		wantErr   bool
+	}{
+		{"number", reflect.TypeOf(0), "-12", int64(-12), false},
+		{"number", reflect.TypeOf(uint(0)), "12", uint64(12), false},
+		{"number", reflect.TypeOf(uint(0)), "-12", nil, true},
+		{"number", reflect.TypeOf(new(int8)), "300", nil, true},
+		{"number", reflect.TypeOf(0.0), "1.5", 1.5, false},
+		{"number", reflect.TypeOf(0.0), "one", nil, true},
+		{"bool", reflect.TypeOf(false), "true", true, false},
+		{"bool", reflect.TypeOf(false), "yes", nil, true},
+		{"date", reflect.TypeOf(time.Time{}), "2024-02-29", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), false},
+		{"date", reflect.TypeOf(time.Time{}), "29/02/2024", nil, true},
+		{"text", reflect.TypeOf(""), "anything", "anything", false},
+	}
+	for _, test := range tests {
+		value, err := parseFieldValue(test.fieldType, test.goType, test.raw)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseFieldValue(%s, %q) error = %v, want error %v", test.fieldType, test.raw, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(value, test.want) {
+			t.Errorf("parseFieldValue(%s, %q) = %#v, want %#v", test.fieldType, test.raw, value, test.want)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if escaped := escapeLike(`50%_off\`); escaped != `50\%\_off\\` {
+		t.Errorf("escapeLike() = %q", escaped)
+	}
+}
+
+func TestApplyFilter(t *testing.T) {
+	tests := []struct {
+		field     string
+		operator  string
+		value     string
+		value2    string
+		wantWhere string
+		wantVars  []interface{}
+	}{
+		{"title", "contains", "50%_off", "", "items.name ILIKE $1", []interface{}{`%50\%\_off%`}},
+		{"title", "notEquals", "draft", "", "NOT items.name = $1", []interface{}{"draft"}},
+		{"title", "blank", "", "", "(items.name IS NULL OR items.name = '')", nil},
+		{"title", "in", "a, b,", "", "(items.name ILIKE $1 OR items.name ILIKE $2)", []interface{}{"%a%", "%b%"}},
+		{"count", ">=", "3", "", "items.count >= $1", []interface{}{int64(3)}},
+		{"price", "between", "1.5", "9", "items.price BETWEEN $1 AND $2", []interface{}{1.5, 9.0}},
+		{"born", "<", "2024-02-29", "", "items.born::date < $1", []interface{}{"2024-02-29"}},
+	}
+	for _, test := range tests {
+		q := newTestListQuery(t)
+		field := filterTestField(test.field)
+		if err := q.applyFilter(field, test.operator, test.value, test.value2); err != nil {
+			t.Errorf("%s %s: %v", test.field, test.operator, err)
+			continue
+		}
+		query, vars := querySql(q)
+		if !strings.Contains(query, "WHERE "+test.wantWhere) {
+			t.Errorf("%s %s: query = %s, want WHERE %s", test.field, test.operator, query, test.wantWhere)
+		}
+		if len(vars) != len(test.wantVars) || (len(vars) > 0 && !reflect.DeepEqual(vars, test.wantVars)) {
+			t.Errorf("%s %s: vars = %#v, want %#v", test.field, test.operator, vars, test.wantVars)
+		}
+	}
+}
+
+func TestApplyFilterRejectsInvalidParameters(t *testing.T) {
+	tests := []struct {
+		field    string
+		operator string
+		value    string
+		param    string
+	}{
+		{"title", ">", "a", "title-operator"},
+		{"count", "contains", "1", "count-operator"},
+		{"secret", "equals", "hunter2", "secret-operator"},
+		{"count", "=", "many", "count-value"},
+		{"price", "between", "1", "price-value2"},
+		{"title", "equals", "", "title-value"},
+		{"title", "in", " , ", "title-value"},
+	}
+	for _, test := range tests {
+		q := newTestListQuery(t)
+		field := filterTestField(test.field)
+		err := q.applyFilter(field, test.operator, test.value, "")
+		var queryErr *QueryError
+		if !errors.As(err, &queryErr) || queryErr.Param != test.param {
+			t.Errorf("%s %s %q: error = %v, want a QueryError on %s", test.field, test.operator, test.value, err, test.param)
+		}
+	}
+}
+
+func TestApplySort(t *testing.T) {
+	q := newTestListQuery(t)
+	if err := q.applySort("-title, count:asc"); err != nil {
+		t.Fatal(err)
+	}
+	if spec := q.sortSpec(); spec != "-title,count" {
+		t.Errorf("sortSpec() = %q", spec)
+	}
+	query, _ := querySql(q)
+	if want := `ORDER BY "items"."name" DESC NULLS LAST,"items"."count" ASC NULLS LAST`; !strings.Contains(query, want) {
+		t.Errorf("query = %s, want %s", query, want)
+	}
+
+	for _, sortParam := range []string{"secret", "unknown", "title:up"} {
+		var queryErr *QueryError
+		if err := newTestListQuery(t).applySort(sortParam); !errors.As(err, &queryErr) || queryErr.Param != "sort" {
+			t.Errorf("applySort(%q) = %v, want a QueryError on sort", sortParam, err)
+		}
+	}
+}
+
+func TestApplyDefaultSortFallsBackToThePrimaryKey(t *testing.T) {
+	q := newTestListQuery(t)
+	q.db = q.db.Where("1 = 1")
+	q.applyDefaultSort("title, nope")
+	if len(q.sorts) != 0 {
+		t.Errorf("sorts = %v, want none", q.sorts)
+	}
+	if query, _ := querySql(q); strings.Contains(query, "ORDER BY") {
+		t.Errorf("query = %s, want no order of the invalid default sort", query)
+	}
+
+	q = newTestListQuery(t)
+	q.db = q.db.Where("1 = 1")
+	q.applyDefaultSort("-title")
+	if query, _ := querySql(q); !strings.Contains(query, `ORDER BY "items"."name" DESC NULLS LAST`) {
+		t.Errorf("query = %s, want the default sort", query)
+	}
+}
+
+func TestKeysetCondition(t *testing.T) {
+	q := &listQuery{tableName: "items", sorts: []sortTerm{
+		{field: "title", column: clause.Column{Table: "items", Name: "name"}, desc: true},
+		{field: "count", column: clause.Column{Table: "items", Name: "count"}},
+	}}
+	tests := []struct {
+		values        []interface{}
+		wantCondition string
+		wantArgs      []interface{}
+	}{
+		{
+			[]interface{}{"b", 2, 10},
+			"(((items.name < ? OR items.name IS NULL)) OR (items.name = ? AND (items.count > ? OR items.count IS NULL)) OR (items.name = ? AND items.count = ? AND items.id > ?))",
+			[]interface{}{"b", "b", 2, "b", 2, 10},
+		},
+		{
+			[]interface{}{nil, 2, 10},
+			"((items.name IS NULL AND (items.count > ? OR items.count IS NULL)) OR (items.name IS NULL AND items.count = ? AND items.id > ?))",
+			[]interface{}{2, 2, 10},
+		},
+		{
+			[]interface{}{nil, nil, 10},
+			"((items.name IS NULL AND items.count IS NULL AND items.id > ?))",
+			[]interface{}{10},
+		},
+	}
+	for _, test := range tests {
+		condition, args := q.keysetCondition("id", test.values)
+		if condition != test.wantCondition {
+			t.Errorf("keysetCondition(%v) = %s, want %s", test.values, condition, test.wantCondition)
+		}
+		if !reflect.DeepEqual(args, test.wantArgs) {
+			t.Errorf("keysetCondition(%v) args = %v, want %v", test.values, args, test.wantArgs)
+		}
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	encoded, err := encodeCursor(listCursor{Sort: "-title", Values: []interface{}{"b", nil, 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor, err := decodeCursor(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.Sort != "-title" || len(cursor.Values) != 3 || cursor.Values[0] != "b" || cursor.Values[1] != nil || cursor.Values[2] != json.Number("10") {
+		t.Errorf("decodeCursor() = %#v", cursor)
+	}
+	if _, err := decodeCursor("not a cursor"); err == nil {
+		t.Error("decodeCursor() accepted a malformed cursor")
+	}
+}
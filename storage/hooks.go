@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// The typed hooks are optional interfaces implemented by the models, they receive the context of the request
+// and the db handle of the operation. The methods of the same names with the former signatures, e.g.
+// PreUpdate() error, are still called by reflection.
+
+// PreUpdater validates or adjusts a record before it's created or updated
+type PreUpdater interface {
+	PreUpdate(ctx context.Context, db *gorm.DB) error
+}
+
+// PostLoader adjusts a record once it's loaded, e.g. to mask a field
+type PostLoader interface {
+	PostLoad(ctx context.Context, db *gorm.DB)
+}
+
+// IdCleaner turns the id of the request into the stored one, e.g. by dropping a prefix
+type IdCleaner interface {
+	CleanId(ctx context.Context, id string) string
+}
+
+// PreFetchConditioner adds conditions to every fetch of the model. It's called on a nil record for lists.
+type PreFetchConditioner interface {
+	PreFetchConditions(ctx context.Context, db *gorm.DB) *gorm.DB
+}
+
+// PreFetchSorter is the default sort of the lists. It's called on a nil record.
+type PreFetchSorter interface {
+	PreFetchSort(ctx context.Context) string
+}
+
+// Titler, ApiUrlProvider and ActionsProvider describe the model in its config, they're read once when the model is registered
+type Titler interface {
+	GetTitle() string
+}
+
+type ApiUrlProvider interface {
+	GetApiUrl() string
+}
+
+type ActionsProvider interface {
+	ExtraActions() string
+}
+
+// hookSignatures lists the accepted signatures of every hook, the typed one first
+var hookSignatures = map[string][]reflect.Type{
+	"PreUpdate":          {interfaceMethod[PreUpdater](), reflect.TypeOf(func() error { return nil })},
+	"PostLoad":           {interfaceMethod[PostLoader](), reflect.TypeOf(func() {})},
+	"CleanId":            {interfaceMethod[IdCleaner](), reflect.TypeOf(func(string) string { return "" })},
+	"PreFetchConditions": {interfaceMethod[PreFetchConditioner](), reflect.TypeOf(func() string { return "" })},
+	"PreFetchSort":       {interfaceMethod[PreFetchSorter](), reflect.TypeOf(func() string { return "" })},
+	"GetTitle":           {interfaceMethod[Titler]()},
+	"GetApiUrl":          {interfaceMethod[ApiUrlProvider]()},
+	"ExtraActions":       {interfaceMethod[ActionsProvider]()},
+	"TableName":          {interfaceMethod[schema.Tabler]()},
+}
+
+func interfaceMethod[I any]() reflect.Type {
+	return reflect.TypeOf((*I)(nil)).Elem().Method(0).Type
+}
+
+// CheckHooks reports the methods of the model named like a hook whose signature isn't accepted, they would
+// never be called. The registry logs them for every model it adds.
+func CheckHooks(model interface{}) error {
+	modelType := reflect.TypeOf(model)
+	if modelType.Kind() != reflect.Ptr {
+		modelType = reflect.PointerTo(modelType)
+	}
+	var names []string
+	for name := range hookSignatures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var hookErrors []error
+	for _, name := range names {
+		signatures := hookSignatures[name]
+		method, found := modelType.MethodByName(name)
+		if !found {
+			continue
+		}
+		signature := methodSignature(method)
+		matches := false
+		for _, accepted := range signatures {
+			matches = matches || signature == accepted
+		}
+		if !matches {
+			hookErrors = append(hookErrors, fmt.Errorf("%s.%s has the signature %s, expected %s",
+				modelType.Elem().Name(), name, signature, signatures[0]))
+		}
+	}
+	return errors.Join(hookErrors...)
+}
+
+// methodSignature is the type of the method without its receiver
+func methodSignature(method reflect.Method) reflect.Type {
+	var in, out []reflect.Type
+	for i := 1; i < method.Type.NumIn(); i++ {
+		in = append(in, method.Type.In(i))
+	}
+	for i := 0; i < method.Type.NumOut(); i++ {
+		out = append(out, method.Type.Out(i))
+	}
+	return reflect.FuncOf(in, out, method.Type.IsVariadic())
+}
+
+func runPreUpdate(db *gorm.DB, record interface{}) error {
+	if hook, ok := record.(PreUpdater); ok {
+		return hook.PreUpdate(db.Statement.Context, db)
+	}
+	_, err := callLegacyHook(record, "PreUpdate")
+	return err
+}
+
+func runPostLoad(db *gorm.DB, record interface{}) {
+	if hook, ok := record.(PostLoader); ok {
+		hook.PostLoad(db.Statement.Context, db)
+		return
+	}
+	callLegacyHook(record, "PostLoad")
+}
+
+// cleanId is the id to fetch, the cleaned one when the model cleans its ids
+func cleanId(db *gorm.DB, record interface{}, id string) string {
+	if hook, ok := record.(IdCleaner); ok {
+		if cleanedId := hook.CleanId(db.Statement.Context, id); cleanedId != "" {
+			return cleanedId
+		}
+		return id
+	}
+	if cleanedId, _ := callLegacyHook(record, "CleanId", reflect.ValueOf(id)); cleanedId != "" {
+		return cleanedId
+	}
+	return id
+}
+
+func applyPreFetchConditions(db *gorm.DB, record interface{}) *gorm.DB {
+	if hook, ok := record.(PreFetchConditioner); ok {
+		return hook.PreFetchConditions(db.Statement.Context, db)
+	}
+	if condition, _ := callLegacyHook(record, "PreFetchConditions"); condition != "" {
+		return db.Where(condition)
+	}
+	return db
+}
+
+func preFetchSort(db *gorm.DB, record interface{}) string {
+	if hook, ok := record.(PreFetchSorter); ok {
+		return hook.PreFetchSort(db.Statement.Context)
+	}
+	defaultSort, _ := callLegacyHook(record, "PreFetchSort")
+	return defaultSort
+}
+
+func modelTitle(model interface{}) string {
+	if titler, ok := model.(Titler); ok {
+		return titler.GetTitle()
+	}
+	return ""
+}
+
+func modelApiUrl(model interface{}) string {
+	if provider, ok := model.(ApiUrlProvider); ok {
+		return provider.GetApiUrl()
+	}
+	return ""
+}
+
+func modelExtraActions(model interface{}) string {
+	if provider, ok := model.(ActionsProvider); ok {
+		return provider.ExtraActions()
+	}
+	return ""
+}
+
+func modelTableName(model interface{}) string {
+	if tabler, ok := model.(schema.Tabler); ok {
+		return tabler.TableName()
+	}
+	return ""
+}
+
+// callLegacyHook calls the hook method by name when it has the former signature, which is one of
+// hookSignatures, methods with any other signature are skipped as CheckHooks reported them
+func callLegacyHook(record interface{}, functionName string, extraParams ...reflect.Value) (outputStr string, outputErr error) {
+	method, found := reflect.TypeOf(record).MethodByName(functionName)
+	if !found {
+		return
+	}
+	signature := methodSignature(method)
+	signatures := hookSignatures[functionName]
+	if len(signatures) < 2 || signature != signatures[1] {
+		return
+	}
+
+	params := append([]reflect.Value{reflect.ValueOf(record)}, extraParams...)
+	results := method.Func.Call(params)
+	if len(results) > 0 && results[0].Kind() == reflect.String {
+		outputStr = results[0].String()
+	} else if len(results) > 0 && !results[0].IsNil() && results[0].Type().Implements(reflect.TypeOf((*error)(nil)).Elem()) {
+		outputErr = results[0].Interface().(error)
+	}
+	return
+}
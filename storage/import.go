@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+)
+
+const maxImportRows = 5000
+const maxImportSize = 10 << 20
+
+// ImportError is a problem found in a row of the imported file, the row is the line number in the file
+type ImportError struct {
+	Row   int    `json:"row"`
+	Field string `json:"field,omitempty"`
+	Error string `json:"error"`
+}
+
+// importColumn maps a column of the file to a config field
+type importColumn struct {
+	index  int
+	field  map[string]any
+	goType reflect.Type
+}
+
+// ImportRecords creates the records of a CSV file in one transaction, rolled back on dryRun=true or a failed row
+func ImportRecords[R Model](c *gin.Context, _ *[]R) {
+	dryRun := c.DefaultQuery("dryRun", "false") == "true"
+	if _, err := GetDb(c); err != nil {
+		return
+	}
+	reader, err := importReader(c)
+	if err != nil {
+		apierrors.Respond(c, apierrors.Wrap(apierrors.BadRequest, err).WithField("file"))
+		return
+	}
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	header, err := csvReader.Read()
+	if err != nil {
+		apierrors.Respond(c, apierrors.New(apierrors.BadRequest, "the file has no header row").WithField("file"))
+		return
+	}
+
+	modelType := reflect.TypeOf(new(R)).Elem()
+	config := filterModelConfig(c, modelType.Name(), registryOf(c).config(modelType.Name()))
+	fields, ok := config["fields"].([]map[string]any)
+	if !ok {
+		apierrors.Respond(c, apierrors.New(apierrors.NotFound, "Model not found!"))
+		return
+	}
+	columns, ignoredColumns := mapImportColumns(modelType, header, fields)
+	if len(columns) == 0 {
+		apierrors.Respond(c, apierrors.New(apierrors.BadRequest, "none of the columns matches a field").
+			With("ignoredColumns", ignoredColumns))
+		return
+	}
+
+	importErrors := []ImportError{}
+	total := 0
+	err = auditedDb(c).Transaction(func(tx *gorm.DB) error {
+		resolver := &nameResolver{c: c, db: tx, registry: registryOf(c), ids: map[string]map[string]any{}}
+		for row := 2; ; row++ {
+			cells, err := csvReader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				importErrors = append(importErrors, ImportError{Row: row, Error: err.Error()})
+				break
+			}
+			if total++; total > maxImportRows {
+				importErrors = append(importErrors, ImportError{Row: row, Error: fmt.Sprintf("a file takes at most %d rows", maxImportRows)})
+				break
+			}
+			importErrors = append(importErrors, importRow[R](ContextWithDb(c, tx), tx, resolver, row, columns, cells)...)
+		}
+		if dryRun || len(importErrors) > 0 {
+			return errRollback
+		}
+		return nil
+	})
+	if err != nil && err != errRollback {
+		apierrors.Respond(c, err)
+		return
+	}
+
+	report := gin.H{
+		"dryRun":         dryRun,
+		"total":          total,
+		"failed":         countFailedRows(importErrors),
+		"errors":         importErrors,
+		"ignoredColumns": ignoredColumns,
+		"imported":       0,
+	}
+	if !dryRun && len(importErrors) > 0 {
+		importErr := apierrors.New(apierrors.Validation, "the file has errors, nothing was imported")
+		for key, value := range report {
+			importErr.With(key, value)
+		}
+		apierrors.Respond(c, importErr)
+		return
+	}
+	if !dryRun {
+		report["imported"] = total
+		report["action"] = "Toast"
+		report["message"] = fmt.Sprintf("%d records imported", total)
+	}
+	log.Printf("Import of %d %s rows, dry run %t, %d errors", total, modelType.Name(), dryRun, len(importErrors))
+	c.JSON(http.StatusOK, report)
+}
+
+func importReader(c *gin.Context) (io.Reader, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+	if !strings.HasPrefix(c.ContentType(), "multipart/") {
+		return c.Request.Body, nil
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("the file form field is required: %w", err)
+	}
+	return fileHeader.Open()
+}
+
+// mapImportColumns matches the header cells to the writable config fields by label or name, ignoring the case
+func mapImportColumns(modelType reflect.Type, header []string, fields []map[string]any) ([]importColumn, []string) {
+	var columns []importColumn
+	var ignoredColumns []string
+	for index, title := range header {
+		title = strings.TrimSpace(strings.TrimPrefix(title, "\ufeff"))
+		fieldIndex := slices.IndexFunc(fields, func(field map[string]any) bool {
+			return strings.EqualFold(fmt.Sprint(field["label"]), title) || strings.EqualFold(fmt.Sprint(field["name"]), title)
+		})
+		if fieldIndex < 0 {
+			ignoredColumns = append(ignoredColumns, title)
+			continue
+		}
+		field := fields[fieldIndex]
+		structField, _ := findModelField(modelType, field["name"].(string))
+		if extras := structField.Tag.Get("extras"); field["readonly"] == true ||
+			strings.Contains(extras, "readonly") || strings.Contains(extras, "hidden") {
+			ignoredColumns = append(ignoredColumns, title)
+			continue
+		}
+		columns = append(columns, importColumn{index: index, field: field, goType: structField.Type})
+	}
+	return columns, ignoredColumns
+}
+
+// importRow converts the cells and creates the record within a savepoint, so the next rows are still validated
+func importRow[R Model](ctx context.Context, tx *gorm.DB, resolver *nameResolver, row int, columns []importColumn, cells []string) []ImportError {
+	var rowErrors []ImportError
+	values := map[string]any{}
+	for _, column := range columns {
+		if column.index >= len(cells) || strings.TrimSpace(cells[column.index]) == "" {
+			continue
+		}
+		fieldName := column.field["name"].(string)
+		value, err := importValue(resolver, column, strings.TrimSpace(cells[column.index]))
+		if err != nil {
+			rowErrors = append(rowErrors, ImportError{Row: row, Field: fmt.Sprint(column.field["label"]), Error: err.Error()})
+			continue
+		}
+		values[fieldName] = value
+	}
+	if len(rowErrors) > 0 {
+		return rowErrors
+	}
+
+	record := new(R)
+	body, err := json.Marshal(values)
+	if err == nil {
+		if err = binding.JSON.BindBody(body, record); err != nil {
+			err = bindingError(record, err)
+		}
+	}
+	if err != nil {
+		return importRowErrors(row, columns, err)
+	}
+	savepoint := fmt.Sprintf("import_row_%d", row)
+	if err := tx.SavePoint(savepoint).Error; err != nil {
+		return []ImportError{{Row: row, Error: err.Error()}}
+	}
+	if err := NewRepo[R]().Create(ctx, record); err != nil {
+		tx.RollbackTo(savepoint)
+		return importRowErrors(row, columns, err)
+	}
+	return nil
+}
+
+// importRowErrors reports a validation error per invalid field, labeled like the columns of the file
+func importRowErrors(row int, columns []importColumn, err error) []ImportError {
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		return []ImportError{{Row: row, Error: err.Error()}}
+	}
+	var rowErrors []ImportError
+	for name, message := range validationErr.Fields {
+		label := name
+		for _, column := range columns {
+			if column.field["name"] == name {
+				label = fmt.Sprint(column.field["label"])
+			}
+		}
+		rowErrors = append(rowErrors, ImportError{Row: row, Field: label, Error: message})
+	}
+	slices.SortFunc(rowErrors, func(a, b ImportError) int { return strings.Compare(a.Field, b.Field) })
+	return rowErrors
+}
+
+func importValue(resolver *nameResolver, column importColumn, rawValue string) (any, error) {
+	field := column.field
+	if isSelectorField(field) {
+		return resolver.resolve(field["selectorOf"].(string), rawValue)
+	}
+	if allowedValues, ok := field["allowedValues"].([]string); ok {
+		index := slices.IndexFunc(allowedValues, func(allowedValue string) bool {
+			return strings.EqualFold(allowedValue, rawValue)
+		})
+		if index < 0 {
+			return nil, fmt.Errorf("%q is not one of %s", rawValue, strings.Join(allowedValues, ", "))
+		}
+		return allowedValues[index], nil
+	}
+	switch configFieldType(field) {
+	case "bool":
+		switch strings.ToLower(rawValue) {
+		case "yes", "y":
+			return true, nil
+		case "no", "n":
+			return false, nil
+		}
+	case "date":
+		if date, err := time.Parse(exportDateLayout, rawValue); err == nil {
+			return date, nil
+		}
+	}
+	if column.goType == nil {
+		return rawValue, nil
+	}
+	return parseFieldValue(configFieldType(field), column.goType, rawValue)
+}
+
+// nameResolver finds the ids of the related records by their name within their Scope
+type nameResolver struct {
+	c        *gin.Context
+	db       *gorm.DB
+	registry *Registry
+	ids      map[string]map[string]any
+}
+
+func (resolver *nameResolver) resolve(selectorOf string, name string) (any, error) {
+	key := strings.ToLower(name)
+	if id, ok := resolver.ids[selectorOf][key]; ok {
+		return id, nil
+	}
+	selectorModel, err := resolver.registry.newModel(selectorOf)
+	if err != nil {
+		return nil, err
+	}
+	tableName := modelTableName(selectorModel)
+	var ids []any
+	err = applyScope(resolver.c, resolver.db.Session(&gorm.Session{NewDB: true}), selectorModel).Table(tableName).
+		Where("LOWER(name) = LOWER(?)", name).Limit(2).Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no %s named %q", selectorOf, name)
+	}
+	if len(ids) > 1 {
+		return nil, fmt.Errorf("more than one %s is named %q", selectorOf, name)
+	}
+	if resolver.ids[selectorOf] == nil {
+		resolver.ids[selectorOf] = map[string]any{}
+	}
+	resolver.ids[selectorOf][key] = ids[0]
+	return ids[0], nil
+}
+
+func countFailedRows(importErrors []ImportError) int {
+	rows := map[int]bool{}
+	for _, importError := range importErrors {
+		rows[importError.Row] = true
+	}
+	return len(rows)
+}
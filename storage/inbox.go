@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// InboxNotification keeps the notifications of a user, so the ones missed while offline can still be read
+type InboxNotification struct {
+	ID        uint       `json:"id" gorm:"primaryKey" extras:"hidden"`
+	UserId    uint       `json:"user_id" gorm:"index;<-:create" extras:"hidden"`
+	Title     string     `json:"title" extras:"href:url"`
+	Body      string     `json:"body" extras:"block,optional"`
+	Url       string     `json:"url" extras:"hidden"`
+	Read      bool       `json:"read" gorm:"index"`
+	ReadAt    *time.Time `json:"read_at" extras:"hidden"`
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
+
+func (*InboxNotification) TableName() string {
+	return "inbox_notifications"
+}
+
+func (*InboxNotification) GetTitle() string {
+	return "Notifications"
+}
+
+func (*InboxNotification) GetApiUrl() string {
+	return "/api/inbox"
+}
+
+func (*InboxNotification) PreFetchSort() string {
+	return "created_at desc"
+}
+
+// Scope restricts every request to the notifications of the calling user
+func (*InboxNotification) Scope(c *gin.Context, db *gorm.DB) *gorm.DB {
+	user, ok := GetRequestUser(c)
+	if !ok {
+		return db.Where("1 = 0")
+	}
+	return db.Where("inbox_notifications.user_id = ?", user.GetUserId())
+}
+
+// PreUpdate keeps the read time in line with the read flag
+func (record *InboxNotification) PreUpdate(_ context.Context, _ *gorm.DB) error {
+	if !record.Read {
+		record.ReadAt = nil
+	} else if record.ReadAt == nil {
+		now := time.Now()
+		record.ReadAt = &now
+	}
+	return nil
+}
+
+// Callers don't have gin context
+func AddInboxNotification(db *gorm.DB, userId uint, title string, body string, url string) error {
+	return db.Create(&InboxNotification{UserId: userId, Title: title, Body: body, Url: url}).Error
+}
+
+func GetInboxList(c *gin.Context) {
+	GetRecords(c, &[]InboxNotification{})
+}
+
+func GetInboxNotification(c *gin.Context) {
+	GetRecord(c, &InboxNotification{})
+}
+
+// CreateInboxNotification creates a notification for the user_id in the body, the caller needs the
+// "inbox:create" permission, which is refused while no permissions are configured
+func CreateInboxNotification(c *gin.Context) {
+	if permissionChecker == nil || !permissionChecker(c, "inbox:create") {
+		apierrors.Respond(c, apierrors.New(apierrors.Forbidden, "forbidden").With("permission", "inbox:create"))
+		return
+	}
+	CreateRecord(c, &InboxNotification{})
+}
+
+func UpdateInboxNotification(c *gin.Context) {
+	UpdateRecord(c, &InboxNotification{})
+}
+
+func DeleteInboxNotification(c *gin.Context) {
+	DeleteRecord(c, &InboxNotification{})
+}
+
+func MarkAllInboxRead(c *gin.Context) {
+	db, err := GetDb(c)
+	if err != nil {
+		return
+	}
+	result := applyScope(c, db, &InboxNotification{}).Model(&InboxNotification{}).Where("read = ?", false).
+		Updates(map[string]interface{}{"read": true, "read_at": time.Now()})
+	if result.Error != nil {
+		apierrors.Respond(c, result.Error)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"action":  "Toast",
+		"message": "All notifications marked as read",
+		"updated": result.RowsAffected,
+	})
+}
+
+func GetInboxUnreadCount(c *gin.Context) {
+	db, err := GetDb(c)
+	if err != nil {
+		return
+	}
+	var count int64
+	if err := applyScope(c, db, &InboxNotification{}).Model(&InboxNotification{}).Where("read = ?", false).Count(&count).Error; err != nil {
+		apierrors.Respond(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unread": count})
+}
@@ -1,7 +1,6 @@
 package storage
 
 import (
-	"fmt"
 	"log"
 	"reflect"
 	"strings"
@@ -11,34 +10,36 @@ import (
 type Model interface {
 }
 
-var modelConfig = map[string]map[string]any{}
-var typeRegistry = map[string]func() interface{}{}
-
+// AddConfig registers the model in the default registry. A model which can't be registered, e.g. a struct
+// of the same name from another package or a malformed validation rule, is logged and skipped, use
+// Registry.Add or Register to get the error.
 func AddConfig(model interface{}) {
-	title := callFunctionGeneric(model, "GetTitle")
-	apiUrl := callFunctionGeneric(model, "GetApiUrl")
-	extractModelConfig(model, title, apiUrl)
+	if _, err := defaultRegistry.Add(model); err != nil {
+		log.Printf("Model %T isn't registered: %v", model, err)
+	}
 }
 
-func extractModelConfig(model interface{}, title string, apiUrl string) {
+func buildModelConfig(model interface{}) map[string]any {
+	if err := CheckHooks(model); err != nil {
+		log.Printf("Model %T has hooks which won't be called: %v", model, err)
+	}
 	modelType := reflect.TypeOf(model).Elem()
 	fields := extractModelFields(modelType)
 
 	var actions = []string{}
-	if actionsStr := callFunctionGeneric(model, "ExtraActions"); actionsStr != "" {
+	if actionsStr := modelExtraActions(model); actionsStr != "" {
 		actions = strings.Split(actionsStr, ",")
 	}
 
 	log.Printf("Storing model %s configuration", modelType)
 	configJson := map[string]any{
-		"title":   title,
-		"fields":  fields,
-		"actions": actions,
-		"apiUrl":  apiUrl,
+		"title":      modelTitle(model),
+		"fields":     fields,
+		"actions":    actions,
+		"apiUrl":     modelApiUrl(model),
+		"softDelete": isSoftDeletableType(modelType),
 	}
-
-	modelConfig[strings.ToLower(modelType.Name())] = configJson
-	typeRegistry[modelType.Name()] = func() interface{} { return model }
+	return configJson
 }
 
 func extractModelFields(modelType reflect.Type) []map[string]interface{} {
@@ -74,6 +75,12 @@ func extractModelFields(modelType reflect.Type) []map[string]interface{} {
 		if strings.Contains(fieldExtras, "optional") {
 			fieldInfo["optional"] = true
 		}
+		if strings.Contains(fieldExtras, "readonly") {
+			fieldInfo["readonly"] = true
+		}
+		if rules, _ := fieldRules(field); rules != nil {
+			fieldInfo["validation"] = rules
+		}
 		if strings.Contains(fieldExtras, "block") {
 			fieldInfo["block"] = true
 		}
@@ -91,6 +98,11 @@ func extractModelFields(modelType reflect.Type) []map[string]interface{} {
 				fieldInfo["masterSelector"] = configValue
 			}
 		}
+		if strings.Contains(fieldExtras, "permission") {
+			if configValue, ok := getFieldConfigValue(fieldExtras, "permission:"); ok {
+				fieldInfo["permission"] = configValue
+			}
+		}
 		if strings.Contains(fieldExtras, "href") {
 			if configValue, ok := getFieldConfigValue(fieldExtras, "href:"); ok {
 				fieldInfo["href"] = configValue
@@ -143,6 +155,16 @@ func extractModelFields(modelType reflect.Type) []map[string]interface{} {
 	return fields
 }
 
+// hasFieldConfigFlag tells whether the flag is one of the comma separated parts of the field configuration
+func hasFieldConfigFlag(fieldConfiguration string, flag string) bool {
+	for _, part := range strings.Split(fieldConfiguration, ",") {
+		if strings.TrimSpace(part) == flag {
+			return true
+		}
+	}
+	return false
+}
+
 func getFieldConfigValue(fieldConfiguration string, configPrefix string) (string, bool) {
 	fieldConfigParts := strings.Split(fieldConfiguration, ",")
 	for i := range fieldConfigParts {
@@ -154,15 +176,3 @@ func getFieldConfigValue(fieldConfiguration string, configPrefix string) (string
 	}
 	return "", false
 }
-
-func getModelConfig(modelType string) *map[string]interface{} {
-	config := modelConfig[strings.ToLower(modelType)]
-	return &config
-}
-
-func getModel(modelType string) (interface{}, error) {
-	if factory, ok := typeRegistry[modelType]; ok {
-		return factory(), nil
-	}
-	return nil, fmt.Errorf("type %s not found in registry", modelType)
-}
@@ -0,0 +1,493 @@
+package storage
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed openapi.html
+var apiDocsPage string
+
+var apiDocsTemplate = template.Must(template.New("docs").Parse(apiDocsPage))
+
+var openAPITitle = "API"
+var openAPIVersion = "1.0.0"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// ConfigureOpenAPI sets the title and version in the info of the OpenAPI document
+func ConfigureOpenAPI(title string, version string) {
+	openAPITitle = title
+	openAPIVersion = version
+}
+
+// GetOpenAPI serves the OpenAPI document of the registered models, routed as GET /api/openapi.json
+func GetOpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, registryOf(c).OpenAPI())
+}
+
+// ApiDocs serves a Redoc page rendering the OpenAPI document at specUrl, e.g. routed as GET /api/docs
+func ApiDocs(specUrl string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		if err := apiDocsTemplate.Execute(c.Writer, gin.H{"Title": openAPITitle, "SpecUrl": specUrl}); err != nil {
+			apierrors.Respond(c, err)
+		}
+	}
+}
+
+// OpenAPI builds the OpenAPI 3.1 document of the registered models. The routes mounted by RegisterCRUD are
+// documented, or the default ones at the api url of the models registered by AddConfig.
+func (r *Registry) OpenAPI() map[string]any {
+	models := r.snapshot()
+	schemas := map[string]any{"Problem": problemSchema()}
+	paths := map[string]any{}
+	tags := []any{}
+	for _, registered := range models {
+		schemaName := registered.modelType.Name()
+		schemas[schemaName] = r.modelSchema(registered.modelType)
+		routes := registered.routes
+		if apiUrl, _ := registered.config["apiUrl"].(string); len(routes) == 0 && apiUrl != "" {
+			routes = defaultRoutes(strings.TrimSuffix(apiUrl, "/"))
+		}
+		if len(routes) == 0 {
+			continue
+		}
+		tags = append(tags, map[string]any{"name": schemaName, "description": registered.config["title"]})
+		for _, route := range routes {
+			path := openAPIPath(route.path)
+			pathItem, ok := paths[path].(map[string]any)
+			if !ok {
+				pathItem = map[string]any{}
+				paths[path] = pathItem
+			}
+			pathItem[strings.ToLower(route.method)] = modelOperation(registered, route)
+		}
+	}
+
+	responses := map[string]any{}
+	for _, code := range apierrors.Codes() {
+		responses[string(code)] = map[string]any{
+			"description": http.StatusText(apierrors.StatusOf(code)),
+			"content": map[string]any{
+				apierrors.ProblemContentType: map[string]any{"schema": schemaRef("Problem")},
+			},
+		}
+	}
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info":    map[string]any{"title": openAPITitle, "version": openAPIVersion},
+		"tags":    tags,
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas":    schemas,
+			"responses":  responses,
+			"parameters": listParameters(),
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+		"security": []any{map[string]any{"bearerAuth": []string{}}},
+	}
+}
+
+func defaultRoutes(apiUrl string) []modelRoute {
+	return []modelRoute{
+		{method: "GET", path: apiUrl, operation: "list"},
+		{method: "GET", path: apiUrl + "/:id", operation: "get"},
+		{method: "POST", path: apiUrl, operation: "create"},
+		{method: "PUT", path: apiUrl + "/:id", operation: "update"},
+		{method: "DELETE", path: apiUrl + "/:id", operation: "delete"},
+	}
+}
+
+// openAPIPath turns the gin parameters into OpenAPI ones, /api/invoice/:id becomes /api/invoice/{id}
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func modelOperation(registered registeredModel, route modelRoute) map[string]any {
+	schemaName := registered.modelType.Name()
+	record := schemaRef(schemaName)
+	operation := map[string]any{
+		"tags":        []string{schemaName},
+		"operationId": route.operation + schemaName,
+	}
+	var parameters []any
+	if strings.Contains(route.path, "/:id") {
+		parameters = append(parameters, map[string]any{
+			"name": "id", "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+		})
+	}
+	responses := map[string]any{
+		"400": problemRef(apierrors.BadRequest),
+		"401": problemRef(apierrors.Unauthorized),
+		"403": problemRef(apierrors.Forbidden),
+		"500": problemRef(apierrors.Internal),
+		"503": problemRef(apierrors.DbUnavailable),
+	}
+
+	switch route.operation {
+	case "list":
+		operation["summary"] = "List the " + registered.name + " records, filtered, sorted and paginated"
+		for _, name := range []string{"page", "pageSize", "query", "sort", "cursor", "count"} {
+			parameters = append(parameters, map[string]any{"$ref": "#/components/parameters/" + name})
+		}
+		parameters = append(parameters, filterParameters(registered.config)...)
+		responses["200"] = jsonResponse("The page of records", map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"items":       map[string]any{"type": "array", "items": record},
+				"total":       map[string]any{"type": "integer"},
+				"currentPage": map[string]any{"type": "integer"},
+				"totalPages":  map[string]any{"type": "integer"},
+				"nextCursor":  map[string]any{"type": "string", "description": "Sent back as cursor for the next page, empty on the last one"},
+				"serverTime":  map[string]any{"type": "string", "format": "date-time"},
+			},
+		})
+	case "get":
+		operation["summary"] = "Get a " + registered.name + " record by id"
+		responses["200"] = jsonResponse("The record, its version is sent in the ETag header of versioned models", record)
+		responses["404"] = problemRef(apierrors.NotFound)
+	case "create":
+		operation["summary"] = "Create a " + registered.name + " record"
+		operation["requestBody"] = jsonRequestBody(record)
+		responses["200"] = jsonResponse("The created record", record)
+		responses["409"] = problemRef(apierrors.Conflict)
+		responses["422"] = problemRef(apierrors.Validation)
+	case "update":
+		operation["summary"] = "Update a " + registered.name + " record"
+		operation["requestBody"] = jsonRequestBody(record)
+		parameters = append(parameters, map[string]any{
+			"name": "If-Match", "in": "header", "schema": map[string]any{"type": "string"},
+			"description": "The ETag of the record, required by versioned models, * skips the check",
+		})
+		responses["200"] = jsonResponse("The updated record", record)
+		responses["404"] = problemRef(apierrors.NotFound)
+		responses["409"] = problemRef(apierrors.Conflict)
+		responses["422"] = problemRef(apierrors.Validation)
+		responses["428"] = problemRef(apierrors.PreconditionRequired)
+	case "patch":
+		operation["summary"] = "Update the members sent of a " + registered.name + " record"
+		operation["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/merge-patch+json": map[string]any{"schema": map[string]any{"type": "object"}},
+				"application/json-patch+json":  map[string]any{"schema": map[string]any{"type": "array", "items": map[string]any{"type": "object"}}},
+			},
+		}
+		parameters = append(parameters, map[string]any{
+			"name": "If-Match", "in": "header", "schema": map[string]any{"type": "string"},
+			"description": "The ETag of the record, required by versioned models, * skips the check",
+		})
+		responses["200"] = jsonResponse("The patched record", record)
+		responses["404"] = problemRef(apierrors.NotFound)
+		responses["409"] = problemRef(apierrors.Conflict)
+		responses["422"] = problemRef(apierrors.Validation)
+		responses["428"] = problemRef(apierrors.PreconditionRequired)
+	case "delete":
+		operation["summary"] = "Delete a " + registered.name + " record"
+		responses["200"] = jsonResponse("The record is deleted", toastSchema())
+		responses["404"] = problemRef(apierrors.NotFound)
+	case "bulkCreate", "bulkUpdate", "bulkDelete":
+		switch route.operation {
+		case "bulkCreate":
+			operation["summary"] = "Create many " + registered.name + " records"
+			operation["requestBody"] = jsonRequestBody(map[string]any{"type": "array", "items": record})
+		case "bulkUpdate":
+			operation["summary"] = "Update many " + registered.name + " records by their id"
+			operation["requestBody"] = jsonRequestBody(map[string]any{"type": "array", "items": record})
+		default:
+			operation["summary"] = "Delete many " + registered.name + " records by their id"
+			operation["requestBody"] = jsonRequestBody(map[string]any{"type": "array", "items": map[string]any{}})
+		}
+		parameters = append(parameters, map[string]any{
+			"name": "mode", "in": "query",
+			"schema":      map[string]any{"type": "string", "enum": []string{bulkAtomic, bulkBestEffort}, "default": bulkAtomic},
+			"description": "atomic rolls everything back on the first failure, bestEffort only the failed items",
+		})
+		responses["200"] = jsonResponse("The outcome of every item", map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"items": map[string]any{"type": "array", "items": map[string]any{"type": "object"}}},
+		})
+		responses["422"] = problemRef(apierrors.Validation)
+	case "export":
+		operation["summary"] = "Export the " + registered.name + " records matching the filters"
+		for _, name := range []string{"query", "sort"} {
+			parameters = append(parameters, map[string]any{"$ref": "#/components/parameters/" + name})
+		}
+		parameters = append(parameters, map[string]any{
+			"name": "format", "in": "query", "schema": map[string]any{"type": "string", "enum": []string{"csv", "xlsx"}, "default": "csv"},
+		})
+		parameters = append(parameters, filterParameters(registered.config)...)
+		responses["200"] = map[string]any{
+			"description": "The file of the records",
+			"content": map[string]any{
+				"text/csv": map[string]any{"schema": map[string]any{"type": "string"}},
+				"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": map[string]any{"schema": map[string]any{"type": "string", "contentEncoding": "binary"}},
+			},
+		}
+	case "import":
+		operation["summary"] = "Import " + registered.name + " records from a CSV file"
+		operation["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"multipart/form-data": map[string]any{"schema": map[string]any{
+					"type":       "object",
+					"properties": map[string]any{"file": map[string]any{"type": "string", "contentEncoding": "binary"}},
+				}},
+				"text/csv": map[string]any{"schema": map[string]any{"type": "string"}},
+			},
+		}
+		parameters = append(parameters, map[string]any{
+			"name": "dryRun", "in": "query", "schema": map[string]any{"type": "boolean", "default": false},
+			"description": "Validates every row and rolls back",
+		})
+		responses["200"] = jsonResponse("The import report", map[string]any{"type": "object"})
+		responses["422"] = problemRef(apierrors.Validation)
+	case "restore":
+		operation["summary"] = "Restore a deleted " + registered.name + " record"
+		responses["200"] = jsonResponse("The record is restored", toastSchema())
+		responses["404"] = problemRef(apierrors.NotFound)
+	case "purge":
+		operation["summary"] = "Delete a " + registered.name + " record permanently"
+		responses["200"] = jsonResponse("The record is purged", toastSchema())
+		responses["404"] = problemRef(apierrors.NotFound)
+	default:
+		operation["summary"] = "Run the " + route.operation + " action on a " + registered.name + " record"
+		responses["200"] = jsonResponse("The result of the action", map[string]any{"type": "object"})
+		responses["404"] = problemRef(apierrors.NotFound)
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	operation["responses"] = responses
+	return operation
+}
+
+func listParameters() map[string]any {
+	parameter := func(name string, description string, schema map[string]any) map[string]any {
+		return map[string]any{"name": name, "in": "query", "description": description, "schema": schema}
+	}
+	return map[string]any{
+		"page":     parameter("page", "The page number, from 1", map[string]any{"type": "integer", "minimum": 1, "default": 1}),
+		"pageSize": parameter("pageSize", "The records per page", map[string]any{"type": "integer", "minimum": 1, "default": 20}),
+		"query":    parameter("query", "Free text searched in the records", map[string]any{"type": "string"}),
+		"sort":     parameter("sort", "Comma separated fields, prefixed by - for descending, e.g. name,-created_at", map[string]any{"type": "string"}),
+		"cursor":   parameter("cursor", "Switches to keyset pagination, empty for the first page then the nextCursor", map[string]any{"type": "string"}),
+		"count":    parameter("count", "false skips counting the total in cursor mode", map[string]any{"type": "boolean", "default": true}),
+	}
+}
+
+// filterParameters are the <field>-operator, <field>-value and <field>-value2 parameters of the config fields
+func filterParameters(config map[string]any) []any {
+	fields, _ := config["fields"].([]map[string]any)
+	var parameters []any
+	for _, field := range fields {
+		fieldType := configFieldType(field)
+		operators, ok := filterOperators[fieldType]
+		if !ok {
+			continue
+		}
+		name := field["name"].(string)
+		parameters = append(parameters,
+			map[string]any{"name": name + "-operator", "in": "query", "schema": map[string]any{"type": "string", "enum": operators}},
+			map[string]any{"name": name + "-value", "in": "query", "schema": map[string]any{"type": "string"}},
+		)
+		if fieldType == "number" || fieldType == "date" {
+			parameters = append(parameters, map[string]any{
+				"name": name + "-value2", "in": "query", "schema": map[string]any{"type": "string"},
+				"description": "The upper bound of the between operator",
+			})
+		}
+	}
+	return parameters
+}
+
+// modelSchema describes the json of the model, registered models referenced by its fields are $refs
+func (r *Registry) modelSchema(modelType reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for _, field := range reflect.VisibleFields(modelType) {
+		jsonTag := field.Tag.Get("json")
+		if field.Anonymous || !field.IsExported() || jsonTag == "-" {
+			continue
+		}
+		name := jsonFieldName(field)
+		if strings.Split(jsonTag, ",")[0] == "" {
+			name = field.Name
+		}
+		schema := r.typeSchema(field.Type, strings.Contains(jsonTag, ",string"))
+		extras := field.Tag.Get("extras")
+		fieldGorm := field.Tag.Get("gorm")
+		if strings.Contains(extras, "readonly") || strings.Contains(fieldGorm, "primaryKey") ||
+			strings.Contains(fieldGorm, "autoCreateTime") || strings.Contains(fieldGorm, "autoUpdateTime") {
+			schema["readOnly"] = true
+		}
+		if strings.Contains(extras, "sensitive") {
+			schema["writeOnly"] = true
+			schema["format"] = "password"
+		}
+		if values, ok := getFieldConfigValue(extras, "enum:"); ok {
+			schema["enum"] = strings.Split(values, "|")
+		}
+		if rules, _ := fieldRules(field); rules != nil {
+			rules.describe(schema)
+			if rules.Required {
+				required = append(required, name)
+			}
+		}
+		properties[name] = schema
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (r *Registry) typeSchema(fieldType reflect.Type, asString bool) map[string]any {
+	nullable := false
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+		nullable = true
+	}
+	var schema map[string]any
+	switch {
+	case fieldType == timeType:
+		schema = map[string]any{"type": "string", "format": "date-time"}
+	case fieldType == deletedAtType:
+		return map[string]any{"type": []string{"string", "null"}, "format": "date-time"}
+	case fieldType.Kind() == reflect.Struct:
+		if registered, ok := r.lookup(fieldType.Name()); ok && registered.modelType == fieldType {
+			ref := schemaRef(fieldType.Name())
+			if nullable {
+				return map[string]any{"anyOf": []any{ref, map[string]any{"type": "null"}}}
+			}
+			return ref
+		}
+		schema = map[string]any{"type": "object"}
+	case asString:
+		schema = map[string]any{"type": "string"}
+	case fieldType.Kind() == reflect.Bool:
+		schema = map[string]any{"type": "boolean"}
+	case fieldType.Kind() == reflect.Float32 || fieldType.Kind() == reflect.Float64:
+		schema = map[string]any{"type": "number"}
+	case isNumberKind(fieldType):
+		schema = map[string]any{"type": "integer"}
+	case fieldType.Kind() == reflect.String:
+		schema = map[string]any{"type": "string"}
+	case fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() == reflect.Uint8:
+		schema = map[string]any{"type": "string", "contentEncoding": "base64"}
+	case fieldType.Kind() == reflect.Slice || fieldType.Kind() == reflect.Array:
+		schema = map[string]any{"type": "array", "items": r.typeSchema(fieldType.Elem(), false)}
+	case fieldType.Kind() == reflect.Map:
+		schema = map[string]any{"type": "object"}
+	default:
+		return map[string]any{}
+	}
+	if nullable {
+		schema["type"] = []any{schema["type"], "null"}
+	}
+	return schema
+}
+
+// describe adds the rules to the schema of their field
+func (rules *FieldRules) describe(schema map[string]any) {
+	lengthKeys := [2]string{"minLength", "maxLength"}
+	if schema["type"] == "array" {
+		lengthKeys = [2]string{"minItems", "maxItems"}
+	}
+	if rules.Min != nil {
+		schema["minimum"] = *rules.Min
+	}
+	if rules.Max != nil {
+		schema["maximum"] = *rules.Max
+	}
+	if rules.MinLength != nil {
+		schema[lengthKeys[0]] = *rules.MinLength
+	}
+	if rules.MaxLength != nil {
+		schema[lengthKeys[1]] = *rules.MaxLength
+	}
+	if rules.Pattern != "" {
+		schema["pattern"] = rules.Pattern
+	}
+	if rules.Format == "email" {
+		schema["format"] = "email"
+	} else if rules.Format == "url" {
+		schema["format"] = "uri"
+	}
+	if len(rules.OneOf) > 0 {
+		schema["enum"] = rules.OneOf
+	}
+}
+
+func problemSchema() map[string]any {
+	text := map[string]any{"type": "string"}
+	return map[string]any{
+		"type":     "object",
+		"required": []string{"type", "title", "status", "detail", "code"},
+		"properties": map[string]any{
+			"type":     text,
+			"title":    text,
+			"status":   map[string]any{"type": "integer"},
+			"detail":   text,
+			"instance": text,
+			"code":     map[string]any{"type": "string", "enum": apierrors.Codes()},
+			"error":    map[string]any{"type": "string", "deprecated": true, "description": "The detail, for the former clients"},
+			"field":    text,
+			"fields": map[string]any{
+				"type":                 "object",
+				"additionalProperties": text,
+				"description":          "The message of every invalid field of a validation error",
+			},
+		},
+	}
+}
+
+// toastSchema is the body of the handlers answering with a message for model.js to show
+func toastSchema() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"action":  map[string]any{"type": "string"},
+			"message": map[string]any{"type": "string"},
+		},
+	}
+}
+
+func schemaRef(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func problemRef(code apierrors.Code) map[string]any {
+	return map[string]any{"$ref": "#/components/responses/" + string(code)}
+}
+
+func jsonResponse(description string, schema map[string]any) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content":     map[string]any{"application/json": map[string]any{"schema": schema}},
+	}
+}
+
+func jsonRequestBody(schema map[string]any) map[string]any {
+	return map[string]any{
+		"required": true,
+		"content":  map[string]any{"application/json": map[string]any{"schema": schema}},
+	}
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"crypto/subtle"
+	"log"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const maskedPassword = "****"
+
+var passwordHashCost = bcrypt.DefaultCost
+
+// Hash used to verify passwords of unknown users, so they take as long as the known ones
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte(maskedPassword), passwordHashCost)
+	return hash
+})
+
+// Should be called from the child modules to change the bcrypt cost, stored hashes with a different cost
+// are rehashed on the next successful login
+func ConfigurePasswordHashing(cost int) {
+	passwordHashCost = cost
+}
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// VerifyPassword compares the password with the stored hash in constant time. Legacy rows that store the
+// client side SHA-256 of the password as is are still accepted, and flagged to be rehashed.
+func VerifyPassword(storedHash string, password string) (ok bool, needsRehash bool) {
+	if password == "" {
+		return false, false
+	}
+	if !isPasswordHash(storedHash) {
+		return subtle.ConstantTimeCompare([]byte(storedHash), []byte(password)) == 1, true
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(password)); err != nil {
+		return false, false
+	}
+	cost, _ := bcrypt.Cost([]byte(storedHash))
+	return true, cost != passwordHashCost
+}
+
+func isPasswordHash(value string) bool {
+	_, err := bcrypt.Cost([]byte(value))
+	return err == nil
+}
+
+// rehashPassword migrates legacy or outdated password hashes, the column is written directly as the
+// BeforeSave hook would hash the hash again. Failures don't block the login.
+func rehashPassword(db *gorm.DB, user Identity, password string) {
+	hash, err := HashPassword(password)
+	if err == nil {
+		err = db.Model(user).UpdateColumn("password", hash).Error
+	}
+	if err != nil {
+		log.Printf("Failed to rehash password of user %d: %v", user.GetId(), err)
+		return
+	}
+	log.Printf("Rehashed password of user %d", user.GetId())
+}
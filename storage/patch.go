@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// PatchError describes why a patch was rejected, it's the details of the 400 or 409 response
+type PatchError struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+	status int
+}
+
+func (e *PatchError) Error() string {
+	return fmt.Sprintf("invalid patch at %q: %s", e.Path, e.Reason)
+}
+
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+var jsonNull = json.RawMessage("null")
+
+// PatchRecord updates only the members sent as an RFC 7396 merge patch or an RFC 6902 JSON Patch
+func PatchRecord[R Model](c *gin.Context, record *R) {
+	id := c.Param("id")
+	db, err := GetDb(c)
+	if err != nil {
+		return
+	}
+	var conflict *versionConflict
+	err = auditedDb(c).Transaction(func(tx *gorm.DB) error {
+		if err := getLockedRecordById(c, tx, record, id); err != nil {
+			return gorm.ErrRecordNotFound
+		}
+		if err := checkVersion(c, tx, record); err != nil {
+			return err
+		}
+		stored := *record
+		if err := applyPatch(c, tx, record); err != nil {
+			return err
+		}
+		return patchModelRecord(tx, record, &stored)
+	})
+	if err == errPreconditionRequired || errors.As(err, &conflict) {
+		respondVersionError(c, db, record, err)
+		return
+	} else if err != nil {
+		respondError(c, err, apierrors.BadRequest)
+		return
+	}
+	runPostLoad(db, record)
+	setETag(c, db, record)
+	c.JSON(http.StatusOK, record)
+}
+
+// patchModelRecord validates and runs PreUpdate then writes the columns that differ from the stored record
+func patchModelRecord[R Model](db *gorm.DB, record *R, stored *R) error {
+	if err := validateRecord(record); err != nil {
+		return err
+	}
+	if err := runPreUpdate(db, record); err != nil {
+		return err
+	}
+	columns, err := changedColumns(db, stored, record)
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+	if err := bumpVersion(db, record); err != nil {
+		return err
+	}
+	if field, counter := versionField(db, record); counter {
+		columns = append(columns, field.DBName)
+	}
+	err = audited(db, func(tx *gorm.DB) error {
+		before, err := loadAuditSnapshot(tx, record)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(record).Select(columns).Updates(record).Error; err != nil {
+			return err
+		}
+		return writeAudit(tx, AuditUpdate, before, record)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Record patched successfully, columns %v", columns)
+	return nil
+}
+
+func changedColumns(db *gorm.DB, before interface{}, after interface{}) ([]string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(after); err != nil {
+		return nil, err
+	}
+	ctx := db.Statement.Context
+	var columns []string
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName == "" || field.PrimaryKey {
+			continue
+		}
+		from, _ := field.ValueOf(ctx, reflect.ValueOf(before).Elem())
+		to, _ := field.ValueOf(ctx, reflect.ValueOf(after).Elem())
+		if !auditEqual(auditValue(from), auditValue(to)) {
+			columns = append(columns, field.DBName)
+		}
+	}
+	return columns, nil
+}
+
+// applyPatch sets the patched members on the record, a null member resets the field to its zero value
+func applyPatch(c *gin.Context, db *gorm.DB, record interface{}) error {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	currentJson, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	var current map[string]json.RawMessage
+	if err := json.Unmarshal(currentJson, &current); err != nil {
+		return err
+	}
+
+	var patched map[string]json.RawMessage
+	if c.ContentType() == "application/json-patch+json" {
+		patched, err = readJsonPatch(body, current)
+	} else {
+		patched, err = readMergePatch(body, current)
+	}
+	if err != nil {
+		return err
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(record); err != nil {
+		return err
+	}
+	fields := map[string]*schema.Field{}
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName != "" {
+			fields[auditFieldName(field)] = field
+		}
+	}
+	recordValue := reflect.ValueOf(record).Elem()
+	for name, value := range patched {
+		field, ok := fields[name]
+		if !ok {
+			return &PatchError{Path: "/" + name, Reason: "unknown field", status: http.StatusBadRequest}
+		}
+		extras := field.Tag.Get("extras")
+		if field.PrimaryKey || !field.Updatable || field.AutoCreateTime > 0 || field.AutoUpdateTime > 0 ||
+			strings.Contains(extras, "hidden") || strings.Contains(extras, "readonly") {
+			return &PatchError{Path: "/" + name, Reason: "field is read-only", status: http.StatusBadRequest}
+		}
+		fieldValue := field.ReflectValueOf(db.Statement.Context, recordValue)
+		if bytes.Equal(value, jsonNull) {
+			fieldValue.Set(reflect.Zero(field.FieldType))
+			continue
+		}
+		newValue := reflect.New(field.FieldType)
+		if err := json.Unmarshal(value, newValue.Interface()); err != nil {
+			return &PatchError{Path: "/" + name, Reason: "invalid value: " + err.Error(), status: http.StatusBadRequest}
+		}
+		fieldValue.Set(newValue.Elem())
+	}
+	return nil
+}
+
+// readMergePatch merges the patch into the current members, objects are merged recursively per RFC 7396
+func readMergePatch(body []byte, current map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(body, &patch); err != nil || patch == nil {
+		return nil, &PatchError{Path: "", Reason: "a merge patch must be a JSON object", status: http.StatusBadRequest}
+	}
+	patched := map[string]json.RawMessage{}
+	for name, value := range patch {
+		if bytes.Equal(value, jsonNull) {
+			patched[name] = jsonNull
+			continue
+		}
+		merged, err := mergePatch(current[name], value)
+		if err != nil {
+			return nil, err
+		}
+		patched[name] = merged
+	}
+	return patched, nil
+}
+
+func mergePatch(target json.RawMessage, patch json.RawMessage) (json.RawMessage, error) {
+	var patchObject map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &patchObject); err != nil || patchObject == nil {
+		return patch, nil
+	}
+	var targetObject map[string]json.RawMessage
+	if err := json.Unmarshal(target, &targetObject); err != nil || targetObject == nil {
+		targetObject = map[string]json.RawMessage{}
+	}
+	for name, value := range patchObject {
+		if bytes.Equal(value, jsonNull) {
+			delete(targetObject, name)
+			continue
+		}
+		merged, err := mergePatch(targetObject[name], value)
+		if err != nil {
+			return nil, err
+		}
+		targetObject[name] = merged
+	}
+	return json.Marshal(targetObject)
+}
+
+// readJsonPatch applies the add, replace, remove and test operations of top level members
+func readJsonPatch(body []byte, current map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	var operations []jsonPatchOperation
+	if err := json.Unmarshal(body, &operations); err != nil {
+		return nil, &PatchError{Path: "", Reason: "a JSON Patch must be an array of operations", status: http.StatusBadRequest}
+	}
+	patched := map[string]json.RawMessage{}
+	for _, operation := range operations {
+		name, ok := strings.CutPrefix(operation.Path, "/")
+		if !ok || name == "" || strings.Contains(name, "/") {
+			return nil, &PatchError{Path: operation.Path, Reason: "only top level members can be patched", status: http.StatusBadRequest}
+		}
+		name = strings.NewReplacer("~1", "/", "~0", "~").Replace(name)
+		switch operation.Op {
+		case "add", "replace":
+			if len(operation.Value) == 0 {
+				return nil, &PatchError{Path: operation.Path, Reason: "value is required", status: http.StatusBadRequest}
+			}
+			patched[name] = operation.Value
+		case "remove":
+			patched[name] = jsonNull
+		case "test":
+			value, ok := patched[name]
+			if !ok {
+				value = current[name]
+			}
+			if !jsonEqual(value, operation.Value) {
+				return nil, &PatchError{Path: operation.Path, Reason: "test failed", status: http.StatusConflict}
+			}
+		default:
+			return nil, &PatchError{Path: operation.Path, Reason: "unsupported operation " + operation.Op, status: http.StatusBadRequest}
+		}
+	}
+	return patched, nil
+}
+
+func jsonEqual(a json.RawMessage, b json.RawMessage) bool {
+	var aValue, bValue interface{}
+	if json.Unmarshal(a, &aValue) != nil || json.Unmarshal(b, &bValue) != nil {
+		return false
+	}
+	return reflect.DeepEqual(aValue, bValue)
+}
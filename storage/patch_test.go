@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var patchTestRecord = map[string]json.RawMessage{
+	"title":   json.RawMessage(`"Draft"`),
+	"count":   json.RawMessage(`3`),
+	"address": json.RawMessage(`{"city":"Cairo","zip":"11511","geo":{"lat":30}}`),
+}
+
+func TestReadMergePatch(t *testing.T) {
+	patched, err := readMergePatch([]byte(`{"title":"Final","count":null,"address":{"zip":null,"geo":{"lng":31}}}`), patchTestRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"title":   `"Final"`,
+		"count":   `null`,
+		"address": `{"city":"Cairo","geo":{"lat":30,"lng":31}}`,
+	}
+	if len(patched) != len(want) {
+		t.Errorf("patched %d members, want %d", len(patched), len(want))
+	}
+	for name, value := range want {
+		if !jsonEqual(patched[name], json.RawMessage(value)) {
+			t.Errorf("%s = %s, want %s", name, patched[name], value)
+		}
+	}
+
+	for _, body := range []string{`[1]`, `"title"`, `null`, `{`} {
+		var patchErr *PatchError
+		if _, err := readMergePatch([]byte(body), patchTestRecord); !errors.As(err, &patchErr) || patchErr.status != http.StatusBadRequest {
+			t.Errorf("readMergePatch(%s) = %v, want a bad request", body, err)
+		}
+	}
+}
+
+func TestReadJsonPatch(t *testing.T) {
+	patched, err := readJsonPatch([]byte(`[
+		{"op":"test","path":"/title","value":"Draft"},
+		{"op":"replace","path":"/title","value":"Final"},
+		{"op":"test","path":"/title","value":"Final"},
+		{"op":"remove","path":"/count"},
+		{"op":"add","path":"/a~1b","value":{"x":1}}
+	]`), patchTestRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"title": `"Final"`,
+		"count": `null`,
+		"a/b":   `{"x":1}`,
+	}
+	if len(patched) != len(want) {
+		t.Errorf("patched %d members, want %d", len(patched), len(want))
+	}
+	for name, value := range want {
+		if !jsonEqual(patched[name], json.RawMessage(value)) {
+			t.Errorf("%s = %s, want %s", name, patched[name], value)
+		}
+	}
+}
+
+func TestReadJsonPatchRejectsInvalidOperations(t *testing.T) {
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"op":"add"}`, http.StatusBadRequest},
+		{`[{"op":"add","path":"/address/city","value":"Giza"}]`, http.StatusBadRequest},
+		{`[{"op":"add","path":"title","value":"Final"}]`, http.StatusBadRequest},
+		{`[{"op":"replace","path":"/title"}]`, http.StatusBadRequest},
+		{`[{"op":"move","from":"/title","path":"/name"}]`, http.StatusBadRequest},
+		{`[{"op":"test","path":"/count","value":4}]`, http.StatusConflict},
+	}
+	for _, test := range tests {
+		var patchErr *PatchError
+		_, err := readJsonPatch([]byte(test.body), patchTestRecord)
+		if !errors.As(err, &patchErr) || patchErr.status != test.status {
+			t.Errorf("readJsonPatch(%s) = %v, want status %d", test.body, err, test.status)
+		}
+	}
+}
+
+type patchTestItem struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func TestApplyPatchRejectsReadOnlyFields(t *testing.T) {
+	db := newTestDb(t)
+	for _, body := range []string{`{"id":2}`, `{"created_at":"2024-01-01T00:00:00Z"}`, `{"updated_at":null}`, `{"nope":1}`} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPatch, "/items/1", strings.NewReader(body))
+		record := &patchTestItem{ID: 1, Title: "Draft"}
+		var patchErr *PatchError
+		if err := applyPatch(c, db, record); !errors.As(err, &patchErr) || patchErr.status != http.StatusBadRequest {
+			t.Errorf("applyPatch(%s) = %v, want a bad request", body, err)
+		}
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPatch, "/items/1", strings.NewReader(`{"title":"Final"}`))
+	record := &patchTestItem{ID: 1, Title: "Draft"}
+	if err := applyPatch(c, db, record); err != nil || record.Title != "Final" {
+		t.Errorf("applyPatch() = %v, title %q, want Final", err, record.Title)
+	}
+}
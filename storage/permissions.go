@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"maps"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var permissionChecker func(c *gin.Context, permission string) bool
+
+// ConfigurePermissionChecker is called by security.ConfigurePermissions, everything is allowed while no
+// checker is configured
+func ConfigurePermissionChecker(checker func(c *gin.Context, permission string) bool) {
+	permissionChecker = checker
+}
+
+func hasPermission(c *gin.Context, permission string) bool {
+	return permissionChecker == nil || permissionChecker(c, permission)
+}
+
+// filterModelConfig hides the fields and actions the caller may not use, and tells the UI which of the
+// create, update and delete operations are allowed. Permissions are named "<modelType>:<action>".
+func filterModelConfig(c *gin.Context, modelType string, config map[string]any) map[string]any {
+	if config == nil {
+		return nil
+	}
+	resource := strings.ToLower(modelType)
+	filteredConfig := maps.Clone(config)
+
+	var fields []map[string]any
+	if configFields, ok := config["fields"].([]map[string]any); ok {
+		for _, field := range configFields {
+			if permission, ok := field["permission"].(string); !ok || hasPermission(c, permission) {
+				fields = append(fields, field)
+			}
+		}
+	}
+	filteredConfig["fields"] = fields
+
+	actions := []string{}
+	if configActions, ok := config["actions"].([]string); ok {
+		for _, action := range configActions {
+			if hasPermission(c, resource+":"+action) {
+				actions = append(actions, action)
+			}
+		}
+	}
+	filteredConfig["actions"] = actions
+
+	filteredConfig["permissions"] = map[string]bool{
+		"create": hasPermission(c, resource+":create"),
+		"update": hasPermission(c, resource+":update"),
+		"delete": hasPermission(c, resource+":delete"),
+		"purge":  hasPermission(c, resource+":purge"),
+	}
+	return filteredConfig
+}
@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Registry holds the models of an app and their config, keyed by the lowercased struct name which is the
+// modelType of the routes. It's safe for concurrent reads once the models are registered.
+type Registry struct {
+	mu     sync.RWMutex
+	models map[string]*registeredModel
+}
+
+type registeredModel struct {
+	name      string
+	modelType reflect.Type
+	config    map[string]any
+	routes    []modelRoute
+}
+
+// modelRoute is a route mounted by RegisterCRUD, operation is list, get, create, update, delete or the action
+type modelRoute struct {
+	method    string
+	path      string
+	operation string
+}
+
+// ModelHandle is the typed handle of a registered model
+type ModelHandle[T any] struct {
+	registry *Registry
+	name     string
+}
+
+var defaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{models: map[string]*registeredModel{}}
+}
+
+// DefaultRegistry is used by AddConfig and InitDatabaseModels, and by the handlers of requests which
+// didn't go through UseRegistry
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// Register adds the model T to the registry, it fails when another type already has the same name, e.g. a
+// struct of the same name from another package. Registering T again refreshes its config.
+func Register[T any](registry *Registry) (*ModelHandle[T], error) {
+	name, err := registry.Add(new(T))
+	if err != nil {
+		return nil, err
+	}
+	return &ModelHandle[T]{registry: registry, name: name}, nil
+}
+
+// MustRegister is Register for the startup code, it panics on duplicates
+func MustRegister[T any](registry *Registry) *ModelHandle[T] {
+	handle, err := Register[T](registry)
+	if err != nil {
+		panic(err)
+	}
+	return handle
+}
+
+// Add registers a model given as a pointer to its struct and returns its name, it fails on a malformed
+// validation rule
+func (r *Registry) Add(model interface{}) (string, error) {
+	modelType := reflect.TypeOf(model)
+	if modelType.Kind() != reflect.Ptr || modelType.Elem().Kind() != reflect.Struct {
+		return "", fmt.Errorf("model %T must be a pointer to a struct", model)
+	}
+	modelType = modelType.Elem()
+	if _, err := validations(modelType); err != nil {
+		return "", err
+	}
+	name := strings.ToLower(modelType.Name())
+	config := buildModelConfig(model)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if registered, ok := r.models[name]; ok && registered.modelType != modelType {
+		return "", fmt.Errorf("model %s of %s is already registered by %s", name, modelType.PkgPath(), registered.modelType.PkgPath())
+	}
+	registered := &registeredModel{name: name, modelType: modelType, config: config}
+	if previous, ok := r.models[name]; ok {
+		registered.routes = previous.routes
+	}
+	r.models[name] = registered
+	return name, nil
+}
+
+func (r *Registry) addRoute(name string, route modelRoute) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if registered, ok := r.models[name]; ok {
+		registered.routes = append(registered.routes, route)
+	}
+}
+
+// snapshot copies the registered models, sorted by name
+func (r *Registry) snapshot() []registeredModel {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	models := make([]registeredModel, 0, len(r.models))
+	for _, registered := range r.models {
+		registered := *registered
+		registered.routes = slices.Clone(registered.routes)
+		models = append(models, registered)
+	}
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].name < models[j].name
+	})
+	return models
+}
+
+// Names lists the registered models, sorted
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var names []string
+	for name := range r.models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Registry) lookup(name string) (*registeredModel, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	registered, ok := r.models[strings.ToLower(name)]
+	return registered, ok
+}
+
+// config is the config of the model, nil when it's not registered. It's shared, so it must not be changed.
+func (r *Registry) config(name string) map[string]any {
+	if registered, ok := r.lookup(name); ok {
+		return registered.config
+	}
+	return nil
+}
+
+// newModel returns a new zero record of the model, as a pointer to its struct
+func (r *Registry) newModel(name string) (interface{}, error) {
+	if registered, ok := r.lookup(name); ok {
+		return reflect.New(registered.modelType).Interface(), nil
+	}
+	return nil, fmt.Errorf("type %s not found in registry", name)
+}
+
+func (h *ModelHandle[T]) Name() string {
+	return h.name
+}
+
+func (h *ModelHandle[T]) Registry() *Registry {
+	return h.registry
+}
+
+// Config returns a copy of the model config
+func (h *ModelHandle[T]) Config() map[string]any {
+	return maps.Clone(h.registry.config(h.name))
+}
+
+func (h *ModelHandle[T]) New() *T {
+	return new(T)
+}
+
+// UseRegistry makes the handlers of the route group use the registry instead of the default one
+func UseRegistry(registry *Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("registry", registry)
+		c.Next()
+	}
+}
+
+func registryOf(c *gin.Context) *Registry {
+	if registry, ok := c.Get("registry"); ok {
+		if registry, ok := registry.(*Registry); ok {
+			return registry
+		}
+	}
+	return defaultRegistry
+}
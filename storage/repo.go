@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type dbContextKey struct{}
+
+// ContextWithDb makes the repositories use db for ctx, e.g. the transaction of a background job. The db keeps
+// its own context, the one it was begun with.
+func ContextWithDb(ctx context.Context, db *gorm.DB) context.Context {
+	return context.WithValue(ctx, dbContextKey{}, db)
+}
+
+// DbFromContext resolves the db of ctx: the one of ContextWithDb, else the transaction of the
+// TransactionMiddleware or the db of the DBMiddleware when ctx is a gin context, else the connection of
+// InitDatabaseModels bound to ctx.
+func DbFromContext(ctx context.Context) *gorm.DB {
+	if db, ok := ctx.Value(dbContextKey{}).(*gorm.DB); ok {
+		return db
+	}
+	if c := ginContext(ctx); c != nil {
+		for _, key := range []string{"tx", "db"} {
+			if db, ok := c.Get(key); ok {
+				if gormDb, ok := db.(*gorm.DB); ok {
+					return gormDb
+				}
+			}
+		}
+	}
+	return GetDbSpecial().WithContext(ctx)
+}
+
+// ginContext is the gin context ctx is, or derives from, nil outside of requests
+func ginContext(ctx context.Context) *gin.Context {
+	if c, ok := ctx.(*gin.Context); ok {
+		return c
+	}
+	c, _ := ctx.Value(gin.ContextKey).(*gin.Context)
+	return c
+}
+
+// scopeOf applies the model Scope within requests, the code without gin context isn't scoped
+func scopeOf(ctx context.Context, db *gorm.DB, record interface{}) *gorm.DB {
+	if c := ginContext(ctx); c != nil {
+		return applyScope(c, db, record)
+	}
+	return db
+}
+
+// auditedDb is the db of ctx recording the request user, if any, in the audit trail
+func auditedDb(ctx context.Context) *gorm.DB {
+	db := DbFromContext(ctx)
+	if c := ginContext(ctx); c != nil {
+		return withRequestAuditUser(c, db)
+	}
+	return db
+}
+
+// Repo reads and writes the records of the model T through the db of the context, see DbFromContext, so a
+// background job given the context of a transaction joins it. Within a request, the context being the gin
+// one, the model Scope is applied and the request user is recorded in the audit trail.
+type Repo[T any] struct {
+}
+
+func NewRepo[T any]() *Repo[T] {
+	return &Repo[T]{}
+}
+
+// Get loads the record by id, gorm.ErrRecordNotFound when there's none
+func (repo *Repo[T]) Get(ctx context.Context, id string) (*T, error) {
+	record := new(T)
+	if err := repo.load(ctx, record, id); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// List loads every record, sorted by PreFetchSort, with the preloaded associations. It isn't paged, large
+// tables should be read through the listing or the export.
+func (repo *Repo[T]) List(ctx context.Context, preloads ...string) ([]T, error) {
+	db := scopeOf(ctx, DbFromContext(ctx), new(T))
+	db = excludeDeleted(db, new(T))
+	db = prepareModelQuery(db, "", (*[]T)(nil), preloads)
+	if sort := preFetchSort(db, (*T)(nil)); sort != "" {
+		db = db.Order(sort)
+	}
+	var records []T
+	if err := db.Order(stableOrder()).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	for i := range records {
+		runPostLoad(db, &records[i])
+	}
+	return records, nil
+}
+
+// Create validates and creates the record, running its PreUpdate
+func (repo *Repo[T]) Create(ctx context.Context, record *T) error {
+	return createModelRecord(auditedDb(ctx), record)
+}
+
+// Save validates and saves the whole record, running its PreUpdate and bumping its version
+func (repo *Repo[T]) Save(ctx context.Context, record *T) error {
+	return persistRecord(auditedDb(ctx), record)
+}
+
+// Delete deletes the record by id, only marking it for soft deletable models
+func (repo *Repo[T]) Delete(ctx context.Context, id string) error {
+	return repo.remove(ctx, new(T), id)
+}
+
+func (repo *Repo[T]) load(ctx context.Context, record *T, id string) error {
+	db := DbFromContext(ctx)
+	return getRecordById(scopeOf(ctx, db, record), record, id)
+}
+
+// remove deletes the record by id, loading the deleted row into the record
+func (repo *Repo[T]) remove(ctx context.Context, record *T, id string) error {
+	return deleteRecordById(ctx, DbFromContext(ctx), record, id)
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RouteOptions customizes one route of RegisterCRUD, Handler replaces the default handler of the verb
+type RouteOptions struct {
+	Disabled   bool
+	Middleware []gin.HandlerFunc
+	Handler    gin.HandlerFunc
+}
+
+// CRUDOptions customizes the routes of RegisterCRUD, Middleware runs before the middleware of every route.
+// Actions has the handlers of the model ExtraActions, routed as GET <apiUrl>/:id/<action>.
+type CRUDOptions struct {
+	Registry   *Registry
+	Middleware []gin.HandlerFunc
+	List       RouteOptions
+	Get        RouteOptions
+	Create     RouteOptions
+	Update     RouteOptions
+	Patch      RouteOptions
+	Delete     RouteOptions
+	BulkCreate RouteOptions
+	BulkUpdate RouteOptions
+	BulkDelete RouteOptions
+	Export     RouteOptions
+	Import     RouteOptions
+	Restore    RouteOptions
+	Purge      RouteOptions
+	Config     RouteOptions
+	Actions    map[string]RouteOptions
+}
+
+// RegisterCRUD registers the model T and mounts its routes at its GetApiUrl, along with its config at
+// /api/config/<model>. Restore and Purge are only mounted for soft deletable models. It panics on the startup mistakes: a model without api url, a registered name or an
+// extra action without handler.
+func RegisterCRUD[T any](router gin.IRouter, opts CRUDOptions) *ModelHandle[T] {
+	registry := opts.Registry
+	if registry == nil {
+		registry = defaultRegistry
+	}
+	handle := MustRegister[T](registry)
+	apiUrl := strings.TrimSuffix(modelApiUrl(new(T)), "/")
+	if apiUrl == "" {
+		panic(fmt.Sprintf("model %s has no api url, it should implement GetApiUrl", handle.Name()))
+	}
+
+	middleware := opts.Middleware
+	if opts.Registry != nil {
+		middleware = append([]gin.HandlerFunc{UseRegistry(opts.Registry)}, middleware...)
+	}
+	mount := func(operation string, method string, path string, route RouteOptions, handler gin.HandlerFunc) {
+		if route.Disabled {
+			return
+		}
+		if route.Handler != nil {
+			handler = route.Handler
+		}
+		handlers := append(append(append([]gin.HandlerFunc{}, middleware...), route.Middleware...), handler)
+		router.Handle(method, path, handlers...)
+		if operation != "" {
+			// The routes are recorded with the prefix of the group for the OpenAPI document
+			if group, ok := router.(interface{ BasePath() string }); ok {
+				path = strings.TrimSuffix(group.BasePath(), "/") + path
+			}
+			registry.addRoute(handle.Name(), modelRoute{method: method, path: path, operation: operation})
+		}
+		log.Printf("Routed %s %s for model %s", method, path, handle.Name())
+	}
+
+	mount("", "GET", "/api/config/"+handle.Name(), opts.Config, func(c *gin.Context) {
+		c.Params = append(c.Params, gin.Param{Key: "modelType", Value: handle.Name()})
+		GetModelConfig(c)
+	})
+	mount("list", "GET", apiUrl, opts.List, func(c *gin.Context) {
+		GetRecords(c, &[]T{})
+	})
+	mount("get", "GET", apiUrl+"/:id", opts.Get, func(c *gin.Context) {
+		GetRecord(c, new(T))
+	})
+	mount("create", "POST", apiUrl, opts.Create, func(c *gin.Context) {
+		CreateRecord(c, new(T))
+	})
+	mount("update", "PUT", apiUrl+"/:id", opts.Update, func(c *gin.Context) {
+		UpdateRecord(c, new(T))
+	})
+	mount("patch", "PATCH", apiUrl+"/:id", opts.Patch, func(c *gin.Context) {
+		PatchRecord(c, new(T))
+	})
+	mount("delete", "DELETE", apiUrl+"/:id", opts.Delete, func(c *gin.Context) {
+		DeleteRecord(c, new(T))
+	})
+	mount("bulkCreate", "POST", apiUrl+"/bulk", opts.BulkCreate, func(c *gin.Context) {
+		BulkCreateRecords(c, &[]T{})
+	})
+	mount("bulkUpdate", "PUT", apiUrl+"/bulk", opts.BulkUpdate, func(c *gin.Context) {
+		BulkUpdateRecords(c, &[]T{})
+	})
+	mount("bulkDelete", "DELETE", apiUrl+"/bulk", opts.BulkDelete, func(c *gin.Context) {
+		BulkDeleteRecords(c, &[]T{})
+	})
+	mount("export", "GET", apiUrl+"/export", opts.Export, func(c *gin.Context) {
+		ExportRecords(c, &[]T{})
+	})
+	mount("import", "POST", apiUrl+"/import", opts.Import, func(c *gin.Context) {
+		ImportRecords(c, &[]T{})
+	})
+	if isSoftDeletableType(reflect.TypeOf(new(T)).Elem()) {
+		mount("restore", "POST", apiUrl+"/:id/restore", opts.Restore, func(c *gin.Context) {
+			RestoreRecord(c, new(T))
+		})
+		mount("purge", "DELETE", apiUrl+"/:id/purge", opts.Purge, func(c *gin.Context) {
+			PurgeRecord(c, new(T))
+		})
+	}
+
+	for _, action := range handle.Config()["actions"].([]string) {
+		route, ok := opts.Actions[action]
+		if !ok || (route.Handler == nil && !route.Disabled) {
+			panic(fmt.Sprintf("extra action %s of model %s has no handler", action, handle.Name()))
+		}
+		mount(action, "GET", apiUrl+"/:id/"+action, route, nil)
+	}
+	return handle
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type routesTestNote struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	Title     string         `json:"title"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at"`
+}
+
+func (*routesTestNote) GetApiUrl() string {
+	return "/api/notes"
+}
+
+func TestRegisterCRUD(t *testing.T) {
+	router := gin.New()
+	registry := NewRegistry()
+	RegisterCRUD[routesTestNote](router, CRUDOptions{Registry: registry, Purge: RouteOptions{Disabled: true}})
+
+	var mounted []string
+	for _, route := range router.Routes() {
+		mounted = append(mounted, route.Method+" "+route.Path)
+	}
+	want := []string{
+		"GET /api/notes", "GET /api/notes/:id", "POST /api/notes", "PUT /api/notes/:id", "PATCH /api/notes/:id",
+		"DELETE /api/notes/:id", "POST /api/notes/bulk", "PUT /api/notes/bulk", "DELETE /api/notes/bulk",
+		"GET /api/notes/export", "POST /api/notes/import", "POST /api/notes/:id/restore", "GET /api/config/routestestnote",
+	}
+	for _, route := range want {
+		if !slices.Contains(mounted, route) {
+			t.Errorf("%s isn't mounted", route)
+		}
+	}
+	if slices.Contains(mounted, "DELETE /api/notes/:id/purge") {
+		t.Error("the disabled purge route is mounted")
+	}
+
+	registered, _ := registry.lookup("routestestnote")
+	var operations []string
+	for _, route := range registered.routes {
+		operations = append(operations, route.operation)
+	}
+	wantOperations := []string{"list", "get", "create", "update", "patch", "delete", "bulkCreate", "bulkUpdate", "bulkDelete", "export", "import", "restore"}
+	if !slices.Equal(operations, wantOperations) {
+		t.Errorf("operations = %v, want %v", operations, wantOperations)
+	}
+
+	paths := registry.OpenAPI()["paths"].(map[string]any)
+	for path, method := range map[string]string{"/api/notes/{id}": "patch", "/api/notes/bulk": "delete", "/api/notes/export": "get", "/api/notes/{id}/restore": "post"} {
+		operations, _ := paths[path].(map[string]any)
+		if _, ok := operations[method]; !ok {
+			t.Errorf("%s %s isn't documented", method, path)
+		}
+	}
+}
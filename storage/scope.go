@@ -0,0 +1,20 @@
+package storage
+
+import (
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Scoper is implemented by models restricting the rows a request may reach, e.g. to the rows owned by
+// the authenticated user from GetRequestUser. Rows outside the scope are reported as not found.
+// Scope may be called on a nil record, so it shouldn't read the receiver.
+type Scoper interface {
+	Scope(c *gin.Context, db *gorm.DB) *gorm.DB
+}
+
+func applyScope(c *gin.Context, db *gorm.DB, record interface{}) *gorm.DB {
+	if scoper, ok := record.(Scoper); ok {
+		return scoper.Scope(c, db)
+	}
+	return db
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+// softDeleteField returns the deleted-at column, managed when it's a gorm.DeletedAt gorm filters by itself
+func softDeleteField(db *gorm.DB, model interface{}) (field *schema.Field, managed bool) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, false
+	}
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName != "" && field.FieldType == deletedAtType {
+			return field, true
+		}
+	}
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName != "" && strings.Contains(field.Tag.Get("extras"), "softDelete") {
+			return field, false
+		}
+	}
+	return nil, false
+}
+
+// isSoftDeletableType is the reflection only check used while building the model config
+func isSoftDeletableType(modelType reflect.Type) bool {
+	for i := 0; i < modelType.NumField(); i++ {
+		field := modelType.Field(i)
+		if field.Type == deletedAtType || strings.Contains(field.Tag.Get("extras"), "softDelete") {
+			return true
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && isSoftDeletableType(field.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+func deletedColumn(field *schema.Field) clause.Column {
+	return clause.Column{Table: clause.CurrentTable, Name: field.DBName}
+}
+
+// excludeDeleted hides the soft deleted rows of the models marked with extras:"softDelete"
+func excludeDeleted(db *gorm.DB, model interface{}) *gorm.DB {
+	field, managed := softDeleteField(db, model)
+	if field == nil || managed {
+		return db
+	}
+	return db.Where(clause.Eq{Column: deletedColumn(field), Value: nil})
+}
+
+// applyDeletedMode hides the soft deleted rows unless includeDeleted or onlyDeleted is sent
+func applyDeletedMode(c *gin.Context, db *gorm.DB, model interface{}) *gorm.DB {
+	field, _ := softDeleteField(db, model)
+	if field == nil {
+		return db
+	}
+	if c.Query("onlyDeleted") == "true" {
+		return db.Unscoped().Where(clause.Neq{Column: deletedColumn(field), Value: nil})
+	}
+	if c.Query("includeDeleted") == "true" {
+		return db.Unscoped()
+	}
+	return excludeDeleted(db, model)
+}
+
+// deleteModelRecord deletes the record by id into record, soft deletable models only get their deleted-at set
+func deleteModelRecord(db *gorm.DB, record interface{}, id string) *gorm.DB {
+	byId, err := primaryKeyEq(db, record, id)
+	if err != nil {
+		db.AddError(err)
+		return db
+	}
+	field, managed := softDeleteField(db, record)
+	if field == nil || managed {
+		return db.Clauses(clause.Returning{}).Where(byId).Delete(record)
+	}
+	return db.Model(record).Clauses(clause.Returning{}).
+		Where(byId).
+		Where(clause.Eq{Column: deletedColumn(field), Value: nil}).
+		Update(field.DBName, time.Now())
+}
+
+// primaryKeyEq binds the id as a value, gorm would take a non numeric string as raw SQL
+func primaryKeyEq(db *gorm.DB, record interface{}, id string) (clause.Expression, error) {
+	primaryKey, err := primaryField(db, record)
+	if err != nil {
+		return nil, err
+	}
+	return clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: primaryKey.DBName}, Value: id}, nil
+}
+
+// RestoreRecord brings back a soft deleted record
+func RestoreRecord[R Model](c *gin.Context, record *R) {
+	id := c.Param("id")
+	db, err := GetDb(c)
+	if err != nil {
+		return
+	}
+	id = cleanId(db, record, id)
+	field, _ := softDeleteField(db, record)
+	if field == nil {
+		apierrors.Respond(c, apierrors.New(apierrors.BadRequest, "Records of this model can't be restored"))
+		return
+	}
+	byId, err := primaryKeyEq(db, record, id)
+	if err != nil {
+		apierrors.Respond(c, err)
+		return
+	}
+
+	err = audited(withRequestAuditUser(c, db), func(tx *gorm.DB) error {
+		err := applyScope(c, tx, record).Unscoped().
+			Where(byId).
+			Where(clause.Neq{Column: deletedColumn(field), Value: nil}).
+			Take(record).Error
+		if err != nil {
+			return err
+		}
+		before := *record
+		if err := tx.Unscoped().Model(record).Clauses(clause.Returning{}).Update(field.DBName, nil).Error; err != nil {
+			return err
+		}
+		return writeAudit(tx, AuditRestore, &before, record)
+	})
+	if err != nil {
+		respondError(c, err, apierrors.BadRequest)
+		return
+	}
+	runPostLoad(db, record)
+	c.JSON(http.StatusOK, gin.H{
+		"action":  "Toast",
+		"message": "Record restored",
+	})
+}
+
+// PurgeRecord deletes a record permanently, soft deleted or not
+func PurgeRecord[R Model](c *gin.Context, record *R) {
+	id := c.Param("id")
+	db, err := GetDb(c)
+	if err != nil {
+		return
+	}
+	id = cleanId(db, record, id)
+	byId, err := primaryKeyEq(db, record, id)
+	if err != nil {
+		apierrors.Respond(c, err)
+		return
+	}
+	err = audited(withRequestAuditUser(c, db), func(tx *gorm.DB) error {
+		result := applyScope(c, tx, record).Unscoped().Clauses(clause.Returning{}).Where(byId).Delete(record)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return writeAudit(tx, AuditPurge, record, nil)
+	})
+	if err != nil {
+		respondError(c, err, apierrors.BadRequest)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"action":  "Toast",
+		"message": "Record purged",
+	})
+}
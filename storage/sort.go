@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sortTerm is a validated entry of the sort query parameter
+type sortTerm struct {
+	field  string
+	column clause.Column
+	desc   bool
+}
+
+// applySort validates the sort parameter against the model fields, the accepted grammar is a comma
+// separated list of "field", "-field", "+field", "field:asc", "field:desc" or the legacy "field desc"
+func (q *listQuery) applySort(sortParam string) error {
+	for _, part := range strings.Split(sortParam, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fieldName, desc, ok := parseSortTerm(part)
+		if !ok {
+			return &QueryError{Param: "sort", Value: part, Reason: "direction must be asc or desc"}
+		}
+		field, ok := q.findField(fieldName)
+		if !ok || configFieldType(field) == "password" {
+			return &QueryError{Param: "sort", Field: fieldName, Value: part, Reason: "field is not sortable"}
+		}
+
+		column := clause.Column{Table: q.tableName, Name: q.columnName(fieldName)}
+		if isSelectorField(field) {
+			alias, err := q.joinSelector(field)
+			if err != nil {
+				return err
+			}
+			column = clause.Column{Table: alias, Name: "name"}
+		}
+		q.sorts = append(q.sorts, sortTerm{field: fieldName, column: column, desc: desc})
+		q.db = q.db.Order(nullsLastOrder(q.db, column, desc))
+	}
+	return nil
+}
+
+func (q *listQuery) findField(fieldName string) (map[string]any, bool) {
+	for _, field := range q.fields {
+		if field["name"] == fieldName {
+			return field, true
+		}
+	}
+	return nil, false
+}
+
+func parseSortTerm(term string) (fieldName string, desc bool, ok bool) {
+	if name, found := strings.CutPrefix(term, "-"); found {
+		return strings.TrimSpace(name), true, true
+	}
+	if name, found := strings.CutPrefix(term, "+"); found {
+		return strings.TrimSpace(name), false, true
+	}
+
+	fieldName, direction := term, "asc"
+	if name, dir, found := strings.Cut(term, ":"); found {
+		fieldName, direction = name, dir
+	} else if parts := strings.Fields(term); len(parts) == 2 {
+		fieldName, direction = parts[0], parts[1]
+	}
+	switch strings.ToLower(strings.TrimSpace(direction)) {
+	case "asc":
+		return strings.TrimSpace(fieldName), false, true
+	case "desc":
+		return strings.TrimSpace(fieldName), true, true
+	}
+	return fieldName, false, false
+}
+
+// nullsLastOrder sorts the NULLs last in both directions, postgres puts them first when descending, so the
+// keyset condition of the cursor pagination is the same for every sort key
+func nullsLastOrder(db *gorm.DB, column clause.Column, desc bool) clause.OrderByColumn {
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+	return clause.OrderByColumn{Column: clause.Column{Name: db.Statement.Quote(column) + " " + direction + " NULLS LAST", Raw: true}}
+}
+
+// stableOrder appends the primary key as the last sort key so paging is deterministic
+func stableOrder() clause.OrderByColumn {
+	return clause.OrderByColumn{Column: clause.PrimaryColumn}
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RefreshToken is a rotating refresh token, only its hash is stored. Every rotation keeps the family id
+// so a reused token can revoke the whole chain issued from the same login.
+type RefreshToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	FamilyId  string     `json:"family_id" gorm:"index"`
+	UserId    uint       `json:"user_id" gorm:"index"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
+
+func (*RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// RevokedToken blacklists an access token by its jti until it expires
+type RevokedToken struct {
+	Jti       string    `json:"jti" gorm:"primaryKey"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index"`
+}
+
+func (*RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
+func SaveRefreshToken(db *gorm.DB, token *RefreshToken) error {
+	return db.Create(token).Error
+}
+
+func FindRefreshToken(db *gorm.DB, tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
+	if err := db.Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RevokeRefreshToken returns false when the token was already revoked, e.g. by a concurrent rotation
+func RevokeRefreshToken(db *gorm.DB, token *RefreshToken) (bool, error) {
+	result := db.Model(token).Where("revoked_at IS NULL").Update("revoked_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+func RevokeTokenFamily(db *gorm.DB, familyId string) error {
+	return db.Model(&RefreshToken{}).Where("family_id = ? AND revoked_at IS NULL", familyId).
+		Update("revoked_at", time.Now()).Error
+}
+
+func RevokeUserRefreshTokens(db *gorm.DB, userId uint) error {
+	return db.Model(&RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", userId).
+		Update("revoked_at", time.Now()).Error
+}
+
+func RevokeAccessToken(db *gorm.DB, jti string, expiresAt time.Time) error {
+	return db.Save(&RevokedToken{Jti: jti, ExpiresAt: expiresAt}).Error
+}
+
+// IsAccessTokenRevoked tells whether the access token of the jti was revoked, a token without jti can't be
+func IsAccessTokenRevoked(db *gorm.DB, jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+	var count int64
+	err := db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
+
+// PurgeExpiredTokens removes the tokens that can't be used anymore anyway
+func PurgeExpiredTokens(db *gorm.DB) error {
+	now := time.Now()
+	if err := db.Where("expires_at < ?", now).Delete(&RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return db.Where("expires_at < ?", now).Delete(&RefreshToken{}).Error
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+const maxTxAttempts = 3
+
+// errRollback rolls a transaction back without failing it, e.g. once a handler answered with an error
+var errRollback = errors.New("rollback")
+
+// WithTx runs fn as a unit of work in a transaction on the db of ctx, see DbFromContext. Within another
+// transaction, e.g. the one of the TransactionMiddleware, it's nested as a savepoint. An error or a panic of
+// fn rolls it back, and a new transaction failing on a serialization failure (40001) or a deadlock (40P01)
+// is retried, so fn should have no other side effects. tx.Statement.Context carries the transaction for the
+// repositories and the hooks.
+func WithTx(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	db := DbFromContext(ctx)
+	run := func(tx *gorm.DB) error {
+		return fn(tx.WithContext(ContextWithDb(ctx, tx)))
+	}
+	if inTransaction(db) {
+		return db.Transaction(run)
+	}
+	var err error
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		if err = db.Transaction(run); err == nil || !isRetryableTxError(err) {
+			return err
+		}
+		log.Printf("Transaction attempt %d of %d failed, retrying: %v", attempt, maxTxAttempts, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt*attempt) * 10 * time.Millisecond):
+		}
+	}
+	return err
+}
+
+// inTransaction tells whether the db is bound to a transaction, gorm then nests Transaction as a savepoint
+func inTransaction(db *gorm.DB) bool {
+	committer, ok := db.Statement.ConnPool.(gorm.TxCommitter)
+	return ok && committer != nil
+}
+
+func isRetryableTxError(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
+
+// txResponseWriter holds the response back until the transaction of the request is committed, so a failed
+// commit is answered with an error instead of the success the handler wrote. A handler streaming the response
+// flushes it, the rest is then written through and a later commit failure can only be logged.
+type txResponseWriter struct {
+	gin.ResponseWriter
+	status  int
+	written bool
+	body    bytes.Buffer
+	flushed bool
+}
+
+func newTxResponseWriter(writer gin.ResponseWriter) *txResponseWriter {
+	return &txResponseWriter{ResponseWriter: writer, status: http.StatusOK}
+}
+
+func (w *txResponseWriter) WriteHeader(code int) {
+	if w.flushed {
+		w.ResponseWriter.WriteHeader(code)
+	} else if code > 0 && !w.written {
+		w.status = code
+	}
+}
+
+func (w *txResponseWriter) WriteHeaderNow() {
+	if w.flushed {
+		w.ResponseWriter.WriteHeaderNow()
+	} else {
+		w.written = true
+	}
+}
+
+func (w *txResponseWriter) Write(data []byte) (int, error) {
+	if w.flushed {
+		return w.ResponseWriter.Write(data)
+	}
+	w.written = true
+	return w.body.Write(data)
+}
+
+func (w *txResponseWriter) WriteString(s string) (int, error) {
+	if w.flushed {
+		return w.ResponseWriter.WriteString(s)
+	}
+	w.written = true
+	return w.body.WriteString(s)
+}
+
+func (w *txResponseWriter) Status() int {
+	if w.flushed {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *txResponseWriter) Size() int {
+	if w.flushed {
+		return w.ResponseWriter.Size()
+	}
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *txResponseWriter) Written() bool {
+	return w.Size() != -1
+}
+
+func (w *txResponseWriter) Flush() {
+	w.flush()
+	w.ResponseWriter.Flush()
+}
+
+// flush writes the held back response through
+func (w *txResponseWriter) flush() {
+	if w.flushed {
+		return
+	}
+	w.flushed = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.written {
+		w.ResponseWriter.WriteHeaderNow()
+		_, _ = w.ResponseWriter.Write(w.body.Bytes())
+	}
+}
+
+// discard drops the held back response along with its headers
+func (w *txResponseWriter) discard() {
+	header := w.Header()
+	for key := range header {
+		delete(header, key)
+	}
+	w.body.Reset()
+	w.written = false
+	w.status = http.StatusOK
+}
@@ -1,9 +1,9 @@
 package storage
 
 import (
-	"net/http"
-
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type Identity interface {
@@ -12,6 +12,22 @@ type Identity interface {
 	GetRole() string
 }
 
+// RequestUser is the part of the shared.IdentityClaims, stored by the AuthMiddleware, used by storage
+type RequestUser interface {
+	GetUserId() uint
+	GetUsername() string
+	GetRole() string
+}
+
+func GetRequestUser(c *gin.Context) (RequestUser, bool) {
+	user, exists := c.Get("user")
+	if !exists {
+		return nil, false
+	}
+	requestUser, ok := user.(RequestUser)
+	return requestUser, ok
+}
+
 type User struct {
 	ID       uint   `json:"id" gorm:"primaryKey" extras:"hidden"`
 	Name     string `json:"username" gorm:"unique"`
@@ -45,22 +61,60 @@ func (u *User) GetRole() string {
 func GetUserUsingNameAndPassword(c *gin.Context, user Identity) bool {
 	var requestUser User
 	if err := c.BindJSON(&requestUser); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		apierrors.Respond(c, apierrors.New(apierrors.BadRequest, "Invalid request"))
 		return false
 	}
     db, err := GetDb(c)
 	if err != nil {
 		return false
 	}
-	if err := db.Where("name ILIKE ? and password = ?", requestUser.Name, requestUser.Password).
-		First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+
+	var storedPassword string
+	if err := db.Where("LOWER(name) = LOWER(?)", requestUser.Name).First(user).Error; err != nil {
+		VerifyPassword(string(dummyPasswordHash()), requestUser.Password)
+		apierrors.Respond(c, apierrors.New(apierrors.Unauthorized, "Invalid username or password"))
+		return false
+	}
+	if err := db.Model(user).Select("password").Where("id = ?", user.GetId()).Row().Scan(&storedPassword); err != nil {
+		apierrors.Respond(c, apierrors.New(apierrors.Unauthorized, "Invalid username or password"))
 		return false
 	}
+
+	ok, needsRehash := VerifyPassword(storedPassword, requestUser.Password)
+	if !ok {
+		apierrors.Respond(c, apierrors.New(apierrors.Unauthorized, "Invalid username or password"))
+		return false
+	}
+	if needsRehash {
+		rehashPassword(db, user, requestUser.Password)
+	}
 	return true
 }
 
+// BeforeSave called by gorm, hashes every password sent by the client, only the masked placeholder or the
+// stored hash itself keep the stored value
+func (record *User) BeforeSave(tx *gorm.DB) error {
+	if record.Password == "" {
+		return nil
+	}
+	if record.Password == maskedPassword || record.ID != 0 {
+		var stored string
+		err := tx.Session(&gorm.Session{NewDB: true}).Table(tx.Statement.Table).
+			Select("password").Where("id = ?", record.ID).Row().Scan(&stored)
+		if record.Password == maskedPassword || (err == nil && record.Password == stored) {
+			record.Password = stored
+			return err
+		}
+	}
+	hash, err := HashPassword(record.Password)
+	if err != nil {
+		return err
+	}
+	record.Password = hash
+	return nil
+}
+
 // PostLoad called by reflection
 func (record *User) PostLoad() {
-	record.Password = "****"
+	record.Password = maskedPassword
 }
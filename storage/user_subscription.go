@@ -1,9 +1,9 @@
 package storage
 
 import (
-	"net/http"
 	"time"
 
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
 	"github.com/gin-gonic/gin"
 )
 
@@ -30,7 +30,7 @@ func (*Subscription) GetApiUrl() string {
 }
 
 func GetSubscriptionList(c *gin.Context) {
-	c.JSON(http.StatusBadRequest, gin.H{"error": "Operation not supported!"})
+	apierrors.Respond(c, apierrors.New(apierrors.BadRequest, "Operation not supported!"))
 }
 
 func GetSubscription(c *gin.Context) {
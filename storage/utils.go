@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,15 +11,24 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func GetModelConfig(c *gin.Context) {
 	modelType := c.Param("modelType")
 	log.Printf("Getting configuration for %s", modelType)
-	modelConfig := getModelConfig(modelType)
-	c.JSON(http.StatusOK, modelConfig)
+	if !hasPermission(c, strings.ToLower(modelType)+":read") {
+		apierrors.Respond(c, apierrors.New(apierrors.Forbidden, "forbidden"))
+		return
+	}
+	config := filterModelConfig(c, modelType, registryOf(c).config(modelType))
+	if config != nil {
+		config["audit"] = auditEnabled
+	}
+	c.JSON(http.StatusOK, config)
 }
 
 func GetRecords[R Model](c *gin.Context, records *[]R) {
@@ -25,8 +36,8 @@ func GetRecords[R Model](c *gin.Context, records *[]R) {
 }
 
 // Callers don't have gin context
-func GetAllRecords[R Model](records *[]R) {
-	GetAllModelRecords(records, []string{})
+func GetAllRecords[R Model](records *[]R) error {
+	return GetAllModelRecords(records, []string{})
 }
 
 func GetModelRecords[R Model](c *gin.Context, records *[]R, modelTypes []string) {
@@ -38,51 +49,43 @@ func GetModelRecords[R Model](c *gin.Context, records *[]R, modelTypes []string)
 	if err != nil {
 		return
 	}
-	recordType := reflect.TypeOf(records).Elem().Elem().Name()
-	config := *getModelConfig(recordType)
-	tableName := callFunctionSlice(records, "TableName")
-	fields := config["fields"].([]map[string]any)
-	for _, field := range fields {
-		fieldName := field["name"].(string)
-		if filterOperator := getFilterValue(c, fieldName+"-operator"); len(filterOperator) > 0 {
-			filterValue := getFilterValue(c, fieldName+"-value")
-			filterValue2 := getFilterValue(c, fieldName+"-value2")
-
-			if field["type"] == "number" || field["type"] == "bool" {
-				whereClause := fmt.Sprintf("%s.%s %s %s", tableName, fieldName, filterOperator, filterValue)
-				if filterOperator == "between" {
-					whereClause = fmt.Sprintf("%s AND %s", whereClause, filterValue2)
-				}
-				db = db.Where(whereClause)
-			} else if field["type"] == "date" {
-				whereClause := fmt.Sprintf("%s.%s::date %s '%s'", tableName, fieldName, filterOperator, filterValue)
-				if filterOperator == "between" {
-					whereClause = fmt.Sprintf("%s::date AND '%s'", whereClause, filterValue2)
-				}
-				db = db.Where(whereClause)
-			} else if field["type"] == "select" {
-				selectorOf := field["selectorOf"].(string)
-				selectorModel, _ := getModel(selectorOf)
-				selectorTableName := callFunctionGeneric(selectorModel, "TableName")
+	listQuery, err := newRecordsQuery(c, db, records)
+	if err != nil {
+		respondError(c, err, apierrors.Internal)
+		return
+	}
 
-				query, args := processFieldFilter(filterOperator, filterValue, selectorTableName, "name")
-				db = db.Joins("left join "+selectorTableName+" on "+selectorTableName+".id = "+tableName+"."+fieldName).
-					Where(query, args...)
-			} else {
-				query, args := processFieldFilter(filterOperator, filterValue, tableName, fieldName)
-				db = db.Where(query, args...)
-			}
+	// Cursor mode, opted in by sending the cursor parameter (empty for the first page)
+	if cursor, ok := c.GetQuery("cursor"); ok {
+		withCount := c.DefaultQuery("count", "true") != "false"
+		count, nextCursor, err := getModelRecordsAfter(listQuery, query, pageSize, cursor, withCount, records, modelTypes)
+		if err != nil {
+			respondError(c, err, apierrors.Internal)
+			return
+		}
+		for i := range *records {
+			runPostLoad(db, &(*records)[i])
+		}
+		response := gin.H{
+			"items":      records,
+			"nextCursor": nextCursor,
+			"serverTime": time.Now().Format(time.RFC3339),
 		}
+		if withCount {
+			response["total"] = count
+		}
+		c.JSON(http.StatusOK, response)
+		return
 	}
+	db = listQuery.db
 
-	sortFields := strings.Split(c.DefaultQuery("sort", ""), ",")
-	for _, field := range sortFields {
-		db = db.Order(field)
+	count, currentPage, totalPages, err := getModelRecords(db, query, page, pageSize, records, modelTypes)
+	if err != nil {
+		respondError(c, err, apierrors.Internal)
+		return
 	}
-
-	count, currentPage, totalPages := getModelRecords(db, query, page, pageSize, records, modelTypes)
 	for i := range *records {
-		callFunction(&(*records)[i], "PostLoad")
+		runPostLoad(db, &(*records)[i])
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"total":       count,
@@ -93,41 +96,39 @@ func GetModelRecords[R Model](c *gin.Context, records *[]R, modelTypes []string)
 	})
 }
 
-func processFieldFilter(filterOperator string, filterValue string, tableName string, fieldName string) (query interface{}, args []interface{}) {
-	if filterOperator == "in" {
-		var ilikeValues []string
-		for _, val := range strings.Split(filterValue, ",") {
-			ilikeValues = append(ilikeValues, fmt.Sprintf("'%%%s%%'", strings.TrimSpace(val)))
-		}
-		ilikeArray := strings.Join(ilikeValues, ",")
-		whereClause := fmt.Sprintf("%s.%s ILIKE ANY (ARRAY[%s])", tableName, fieldName, ilikeArray)
-		query = whereClause
-	} else if filterOperator == "contains" {
-		query = fmt.Sprintf("%s.%s ILIKE ?", tableName, fieldName)
-		args = []interface{}{"%" + filterValue + "%"}
-	} else if filterOperator == "notContains" {
-		query = fmt.Sprintf("NOT %s.%s ILIKE ?", tableName, fieldName)
-		args = []interface{}{"%" + filterValue + "%"}
-	} else if filterOperator == "equals" {
-		query = fmt.Sprintf("%s.%s = ?", tableName, fieldName)
-		args = []interface{}{filterValue}
-	} else if filterOperator == "notEquals" {
-		query = fmt.Sprintf("NOT %s.%s = ?", tableName, fieldName)
-		args = []interface{}{filterValue}
-	} else if filterOperator == "blank" {
-		query = fmt.Sprintf("%s.%s IS NULL OR %s.%s = ''", tableName, fieldName, tableName, fieldName)
-	} else if filterOperator == "notBlank" {
-		query = fmt.Sprintf("%s.%s IS NOT NULL AND NOT %s.%s = ''", tableName, fieldName, tableName, fieldName)
+// newRecordsQuery applies the scope, the deleted rows mode, the filters and the sort of the request
+func newRecordsQuery[R Model](c *gin.Context, db *gorm.DB, records *[]R) (*listQuery, error) {
+	db = applyScope(c, db, new(R))
+	db = applyDeletedMode(c, db, new(R))
+	recordType := reflect.TypeOf(records).Elem().Elem()
+	registry := registryOf(c)
+	config := registry.config(recordType.Name())
+	tableName := modelTableName((*R)(nil))
+	fields, ok := config["fields"].([]map[string]any)
+	if !ok {
+		return nil, apierrors.New(apierrors.NotFound, "Model not found!")
 	}
-	return
+	listQuery := newListQuery(db, registry, recordType, tableName, fields)
+	if err := listQuery.applyFilters(c); err != nil {
+		return nil, err
+	}
+	if err := listQuery.applySort(c.DefaultQuery("sort", "")); err != nil {
+		return nil, err
+	}
+	return listQuery, nil
 }
 
 // Callers don't have gin context
-func GetAllModelRecords[R Model](records *[]R, modelTypes []string) {
-	getModelRecords(GetDbSpecial(), "", 1, 1000, records, modelTypes)
+func GetAllModelRecords[R Model](records *[]R, modelTypes []string) error {
+	found, err := NewRepo[R]().List(context.Background(), modelTypes...)
+	if err != nil {
+		return err
+	}
+	*records = found
+	return nil
 }
 
-func getModelRecords[R Model](db *gorm.DB, query string, page int, pageSize int, records *[]R, modelTypes []string) (count int64, currentPage int, totalPages int) {
+func getModelRecords[R Model](db *gorm.DB, query string, page int, pageSize int, records *[]R, modelTypes []string) (count int64, currentPage int, totalPages int, err error) {
 	if page < 1 {
 		page = 1
 	}
@@ -136,23 +137,19 @@ func getModelRecords[R Model](db *gorm.DB, query string, page int, pageSize int,
 	}
 	offset := (page - 1) * pageSize
 
-	for i := 0; i < len(modelTypes); i++ {
-		db = db.Preload(modelTypes[i])
-	}
-	if query != "" {
-		tableName := callFunctionSlice(records, "TableName")
-		db = db.Where(tableName+".name ILIKE ?", "%"+query+"%")
-	}
-	if condition := callFunctionSlice(records, "PreFetchConditions"); condition != "" {
-		db = db.Where(condition)
-	}
-	if sort := callFunctionSlice(records, "PreFetchSort"); sort != "" {
+	db = prepareModelQuery(db, query, records, modelTypes)
+	if sort := preFetchSort(db, (*R)(nil)); sort != "" {
 		db = db.Order(sort)
 	}
+	db = db.Order(stableOrder())
 
 	var nilRecord *R = nil
-	db.Model(nilRecord).Count(&count)
-	db.Offset(offset).Limit(pageSize).Find(records)
+	if err = db.Model(nilRecord).Count(&count).Error; err != nil {
+		return
+	}
+	if err = db.Offset(offset).Limit(pageSize).Find(records).Error; err != nil {
+		return
+	}
 
 	currentPage = (offset / pageSize) + 1
 	totalPages = int((count + int64(pageSize) - 1) / int64(pageSize))
@@ -161,6 +158,18 @@ func getModelRecords[R Model](db *gorm.DB, query string, page int, pageSize int,
 	return
 }
 
+// prepareModelQuery applies the preloads, the name search and the model PreFetchConditions
+func prepareModelQuery[R Model](db *gorm.DB, query string, records *[]R, modelTypes []string) *gorm.DB {
+	for i := 0; i < len(modelTypes); i++ {
+		db = db.Preload(modelTypes[i])
+	}
+	if query != "" {
+		tableName := modelTableName((*R)(nil))
+		db = db.Where(tableName+".name ILIKE ?", "%"+query+"%")
+	}
+	return applyPreFetchConditions(db, (*R)(nil))
+}
+
 func GetRecord[R Model](c *gin.Context, record *R) {
 	id := c.Param("id")
 
@@ -168,38 +177,41 @@ func GetRecord[R Model](c *gin.Context, record *R) {
 	if err != nil {
 		return
 	}
-	if err := getRecordById(db, record, id); err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+	if err := NewRepo[R]().load(c, record, id); err != nil {
+		respondError(c, err, apierrors.NotFound)
 		return
 	}
+	setETag(c, db, record)
 	c.JSON(http.StatusOK, record)
 }
 
 // Callers don't have gin context
 func GetRecordById[R Model](record *R, id string) error {
-	db := GetDbSpecial()
-	return getRecordById(db, record, id)
+	return NewRepo[R]().load(context.Background(), record, id)
 }
 
 func getRecordById[R Model](db *gorm.DB, record *R, id string) (err error) {
 	if id == "" {
 		return fmt.Errorf("Can't get record with empty ID")
 	}
-	if cleanedId, _ := callFunction(record, "CleanId", reflect.ValueOf(id)); cleanedId != "" {
-		id = cleanedId
-	}
-	if condition, _ := callFunction(record, "PreFetchConditions"); condition != "" {
-		db = db.Where(condition)
-	}
+	id = cleanId(db, record, id)
+	db = applyPreFetchConditions(db, record)
+	db = excludeDeleted(db, record)
 	err = db.Where("id", id).First(record).Error
-	callFunction(record, "PostLoad")
+	runPostLoad(db, record)
 	return
 }
 
+// getLockedRecordById loads the record within the request scope and locks its row for the version check
+func getLockedRecordById[R Model](ctx context.Context, tx *gorm.DB, record *R, id string) error {
+	locking := clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: clause.CurrentTable}}
+	return getRecordById(scopeOf(ctx, tx, record).Clauses(locking), record, id)
+}
+
 func CreateRecord[R Model](c *gin.Context, record *R) {
 	log.Println("Creating record from request")
 	if err := c.ShouldBindJSON(record); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, bindingError(record, err), apierrors.BadRequest)
 		return
 	}
 	log.Println("Loaded record from request")
@@ -207,29 +219,34 @@ func CreateRecord[R Model](c *gin.Context, record *R) {
 	if err != nil {
 		return
 	}
-	if err := createModelRecord(db, record); err != nil {
-		errorCode := http.StatusBadRequest
-		if strings.HasPrefix(err.Error(), "conflict") {
-			errorCode = http.StatusConflict
-		}
-		c.JSON(errorCode, gin.H{"error": err.Error()})
+	if err := NewRepo[R]().Create(c, record); err != nil {
+		respondError(c, err, apierrors.BadRequest)
 		return
 	}
-	callFunction(record, "PostLoad")
+	runPostLoad(db, record)
+	setETag(c, db, record)
 	c.JSON(http.StatusOK, record)
 }
 
 // Callers don't have gin context
 func CreateModelRecord[R Model](record *R) error {
-	db := GetDbSpecial()
-	return createModelRecord(db, record)
+	return NewRepo[R]().Create(context.Background(), record)
 }
 
 func createModelRecord[R Model](db *gorm.DB, record *R) error {
-	if _, err := callFunction(record, "PreUpdate"); err != nil {
+	if err := validateRecord(record); err != nil {
+		return err
+	}
+	if err := runPreUpdate(db, record); err != nil {
 		return err
 	}
-	if err := db.Create(record).Error; err != nil {
+	err := audited(db, func(tx *gorm.DB) error {
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		return writeAudit(tx, AuditCreate, nil, record)
+	})
+	if err != nil {
 		return err
 	}
 	log.Println("Record created successfully")
@@ -242,37 +259,67 @@ func UpdateRecord[R Model](c *gin.Context, record *R) {
 	if err != nil {
 		return
 	}
-	if err := getRecordById(db, record, id); err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
-		return
-	}
-	if err := c.ShouldBindJSON(record); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-	if err := persistRecord(db, record); err != nil {
-		errorCode := http.StatusBadRequest
-		if strings.HasPrefix(err.Error(), "conflict") {
-			errorCode = http.StatusConflict
+	var conflict *versionConflict
+	err = auditedDb(c).Transaction(func(tx *gorm.DB) error {
+		if err := getLockedRecordById(c, tx, record, id); err != nil {
+			return gorm.ErrRecordNotFound
+		}
+		if err := checkVersion(c, tx, record); err != nil {
+			return err
+		}
+		stored := *record
+		if err := c.ShouldBindJSON(record); err != nil {
+			return bindingError(record, err)
+		}
+		if err := keepPrimaryKey(tx, record, &stored); err != nil {
+			return err
 		}
-		c.JSON(errorCode, gin.H{"error": err.Error()})
+		if err := keepVersion(tx, record, &stored); err != nil {
+			return err
+		}
+		return NewRepo[R]().Save(ContextWithDb(c, tx), record)
+	})
+	if err == errPreconditionRequired || errors.As(err, &conflict) {
+		respondVersionError(c, db, record, err)
+		return
+	} else if err != nil {
+		respondError(c, err, apierrors.BadRequest)
 		return
 	}
-	callFunction(record, "PostLoad")
+	runPostLoad(db, record)
+	setETag(c, db, record)
 	c.JSON(http.StatusOK, record)
 }
 
 // Callers don't have gin context
 func PersistRecord[R Model](record *R) error {
-	db := GetDbSpecial()
-	return persistRecord(db, record)
+	return NewRepo[R]().Save(context.Background(), record)
 }
 
 func persistRecord[R Model](db *gorm.DB, record *R) error {
-	if _, err := callFunction(record, "PreUpdate"); err != nil {
+	if err := validateRecord(record); err != nil {
+		return err
+	}
+	if err := runPreUpdate(db, record); err != nil {
+		return err
+	}
+	if err := bumpVersion(db, record); err != nil {
 		return err
 	}
-	if err := db.Save(record).Error; err != nil {
+	err := audited(db, func(tx *gorm.DB) error {
+		before, err := loadAuditSnapshot(tx, record)
+		if err != nil {
+			return err
+		}
+		if err := tx.Save(record).Error; err != nil {
+			return err
+		}
+		if before == nil {
+			return writeAudit(tx, AuditCreate, nil, record)
+		}
+		return writeAudit(tx, AuditUpdate, before, record)
+	})
+	if err != nil {
 		return err
 	}
 	log.Println("Record updated successfully")
@@ -281,15 +328,11 @@ func persistRecord[R Model](db *gorm.DB, record *R) error {
 
 func DeleteRecord[R Model](c *gin.Context, record *R) {
 	id := c.Param("id")
-	if cleanedId, _ := callFunction(record, "CleanId", reflect.ValueOf(id)); cleanedId != "" {
-		id = cleanedId
-	}
-	db, err := GetDb(c)
-	if err != nil {
+	if _, err := GetDb(c); err != nil {
 		return
 	}
-	if err := db.Delete(record, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+	if err := NewRepo[R]().remove(c, record, id); err != nil {
+		respondError(c, err, apierrors.BadRequest)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
@@ -298,42 +341,26 @@ func DeleteRecord[R Model](c *gin.Context, record *R) {
 	})
 }
 
+// deleteRecordById deletes the record within the request scope, gorm.ErrRecordNotFound when there's none
+func deleteRecordById[R Model](ctx context.Context, db *gorm.DB, record *R, id string) error {
+	id = cleanId(db, record, id)
+	if c := ginContext(ctx); c != nil {
+		db = withRequestAuditUser(c, db)
+	}
+	return audited(db, func(tx *gorm.DB) error {
+		// Returning loads the deleted row into the record for its audit entry
+		result := deleteModelRecord(scopeOf(ctx, tx, record), record, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return writeAudit(tx, AuditDelete, record, nil)
+	})
+}
+
 func getFilterValue(c *gin.Context, fieldName string) string {
 	filterValue := c.DefaultQuery(fieldName, "")
 	return strings.TrimSpace(filterValue)
 }
-
-func callFunctionGeneric(record interface{}, functionName string) string {
-	modelType := reflect.TypeOf(record)
-	output, _ := callFunctionType(modelType, record, functionName)
-	return output
-}
-
-func callFunctionSlice[R Model](_ *[]R, functionName string) string {
-	var nilRecord *R = nil
-	output, _ := callFunction(nilRecord, functionName)
-	return output
-}
-
-func callFunction[R Model](record *R, functionName string, extraParams ...reflect.Value) (outputStr string, outputErr error) {
-	modelType := reflect.TypeOf(record)
-	return callFunctionType(modelType, record, functionName, extraParams...)
-}
-
-func callFunctionType(modelType reflect.Type, record interface{}, functionName string, extraParams ...reflect.Value) (outputStr string, outputErr error) {
-	if method, found := modelType.MethodByName(functionName); found {
-		log.Printf("Found %s function, calling it", functionName)
-
-		var params []reflect.Value
-		params = append(params, reflect.ValueOf(record))
-		params = append(params, extraParams...)
-
-		results := method.Func.Call(params)
-		if len(results) > 0 && results[0].Kind() == reflect.String {
-			outputStr = results[0].String()
-		} else if len(results) > 0 && !results[0].IsNil() && results[0].Type().Implements(reflect.TypeOf((*error)(nil)).Elem()) {
-			outputErr = results[0].Interface().(error)
-		}
-	}
-	return
-}
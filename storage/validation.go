@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldRules are the validation rules of a field, declared in the binding tag (required, min=, max=, len=,
+// email, url, oneof=) or in extras (required, min:, max:, length:, email, url, enum:, regex:). Min and max
+// bound the value of numbers and the length of texts. They're exported in the model config as "validation".
+type FieldRules struct {
+	Required  bool     `json:"required,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+	MinLength *int     `json:"minLength,omitempty"`
+	MaxLength *int     `json:"maxLength,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Format    string   `json:"format,omitempty"`
+	OneOf     []string `json:"oneOf,omitempty"`
+	pattern   *regexp.Regexp
+}
+
+// ValidationError maps the json name of every invalid field to its message, it's returned as 422
+type ValidationError struct {
+	Fields map[string]string `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	var messages []string
+	for name, message := range e.Fields {
+		messages = append(messages, name+" "+message)
+	}
+	sort.Strings(messages)
+	return "validation failed: " + strings.Join(messages, ", ")
+}
+
+type fieldValidation struct {
+	name  string
+	index []int
+	rules *FieldRules
+}
+
+var modelValidations sync.Map
+
+// fieldRules reads the rules of the struct field, nil when it has none. A malformed bound or regex is an error.
+func fieldRules(field reflect.StructField) (*FieldRules, error) {
+	rules := &FieldRules{}
+	isNumber := isNumberKind(field.Type)
+	setBound := func(rule string, value string) error {
+		bound, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s rule %q of field %s", rule, value, field.Name)
+		}
+		length := int(bound)
+		switch {
+		case rule == "min" && isNumber:
+			rules.Min = &bound
+		case rule == "max" && isNumber:
+			rules.Max = &bound
+		case rule == "min":
+			rules.MinLength = &length
+		case rule == "max":
+			rules.MaxLength = &length
+		}
+		return nil
+	}
+
+	for _, rule := range strings.Split(field.Tag.Get("binding"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		var err error
+		switch name {
+		case "required":
+			rules.Required = true
+		case "min", "max":
+			err = setBound(name, value)
+		case "len":
+			if err = setBound("min", value); err == nil {
+				err = setBound("max", value)
+			}
+		case "email", "url":
+			rules.Format = name
+		case "oneof":
+			rules.OneOf = strings.Fields(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The pattern takes the rest of the extras as it may contain commas
+	extras, pattern, hasPattern := strings.Cut(field.Tag.Get("extras"), "regex:")
+	if hasPattern {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex rule of field %s: %w", field.Name, err)
+		}
+		rules.Pattern = pattern
+		rules.pattern = compiled
+	}
+	if hasFieldConfigFlag(extras, "required") {
+		rules.Required = true
+	}
+	for _, format := range []string{"email", "url"} {
+		if hasFieldConfigFlag(extras, format) {
+			rules.Format = format
+		}
+	}
+	for _, rule := range []string{"min", "max"} {
+		if value, ok := getFieldConfigValue(extras, rule+":"); ok {
+			if err := setBound(rule, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if value, ok := getFieldConfigValue(extras, "length:"); ok {
+		minLength, maxLength, isRange := strings.Cut(value, "-")
+		if !isRange {
+			maxLength = minLength
+		}
+		if err := setBound("min", minLength); err != nil {
+			return nil, err
+		}
+		if err := setBound("max", maxLength); err != nil {
+			return nil, err
+		}
+	}
+	if value, ok := getFieldConfigValue(extras, "enum:"); ok {
+		rules.OneOf = strings.Split(value, "|")
+	}
+
+	if reflect.DeepEqual(rules, &FieldRules{}) {
+		return nil, nil
+	}
+	return rules, nil
+}
+
+// validations lists the fields of the model type having rules, embedded fields included
+func validations(modelType reflect.Type) ([]fieldValidation, error) {
+	if cached, ok := modelValidations.Load(modelType); ok {
+		return cached.([]fieldValidation), nil
+	}
+	var fields []fieldValidation
+	for _, field := range reflect.VisibleFields(modelType) {
+		if field.Anonymous || !field.IsExported() {
+			continue
+		}
+		rules, err := fieldRules(field)
+		if err != nil {
+			return nil, fmt.Errorf("model %s: %w", modelType.Name(), err)
+		}
+		if rules != nil {
+			fields = append(fields, fieldValidation{name: jsonFieldName(field), index: field.Index, rules: rules})
+		}
+	}
+	modelValidations.Store(modelType, fields)
+	return fields, nil
+}
+
+// validateRecord checks the rules of every field, it returns a *ValidationError listing the invalid ones
+func validateRecord(record interface{}) error {
+	recordValue := reflect.Indirect(reflect.ValueOf(record))
+	if recordValue.Kind() != reflect.Struct {
+		return nil
+	}
+	fieldValidations, err := validations(recordValue.Type())
+	if err != nil {
+		return err
+	}
+	invalidFields := map[string]string{}
+	for _, field := range fieldValidations {
+		value, err := recordValue.FieldByIndexErr(field.index)
+		if err != nil {
+			continue
+		}
+		if message := field.rules.check(value); message != "" {
+			invalidFields[field.name] = message
+		}
+	}
+	if len(invalidFields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: invalidFields}
+}
+
+func (rules *FieldRules) check(value reflect.Value) string {
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	if value.Kind() == reflect.Ptr {
+		if rules.Required {
+			return "is required"
+		}
+		return ""
+	}
+	// An optional empty value is left out, but a zero number is still a value to check the bounds of
+	isNumber := value.CanInt() || value.CanUint() || value.CanFloat()
+	if value.IsZero() {
+		if rules.Required {
+			return "is required"
+		}
+		if !isNumber {
+			return ""
+		}
+	}
+
+	switch {
+	case isNumber:
+		number, _ := strconv.ParseFloat(fmt.Sprint(value.Interface()), 64)
+		if rules.Min != nil && number < *rules.Min {
+			return fmt.Sprintf("must be at least %v", *rules.Min)
+		}
+		if rules.Max != nil && number > *rules.Max {
+			return fmt.Sprintf("must be at most %v", *rules.Max)
+		}
+	case value.Kind() == reflect.String:
+		text := value.String()
+		length := utf8.RuneCountInString(text)
+		if rules.MinLength != nil && length < *rules.MinLength {
+			return fmt.Sprintf("must be at least %d characters", *rules.MinLength)
+		}
+		if rules.MaxLength != nil && length > *rules.MaxLength {
+			return fmt.Sprintf("must be at most %d characters", *rules.MaxLength)
+		}
+		if rules.pattern != nil && !rules.pattern.MatchString(text) {
+			return "has an invalid format"
+		}
+		if rules.Format == "email" && !isEmail(text) {
+			return "must be a valid email address"
+		}
+		if rules.Format == "url" && !isUrl(text) {
+			return "must be a valid URL"
+		}
+		if len(rules.OneOf) > 0 && !slices.Contains(rules.OneOf, text) {
+			return "must be one of " + strings.Join(rules.OneOf, ", ")
+		}
+	case value.Kind() == reflect.Slice:
+		if rules.MinLength != nil && value.Len() < *rules.MinLength {
+			return fmt.Sprintf("must have at least %d items", *rules.MinLength)
+		}
+		if rules.MaxLength != nil && value.Len() > *rules.MaxLength {
+			return fmt.Sprintf("must have at most %d items", *rules.MaxLength)
+		}
+	}
+	return ""
+}
+
+// bindingError turns the validator errors of gin's binding into a *ValidationError keyed by json names
+func bindingError(record interface{}, err error) error {
+	var validatorErrors validator.ValidationErrors
+	if !errors.As(err, &validatorErrors) {
+		return err
+	}
+	if validationErr := validateRecord(record); validationErr != nil {
+		return validationErr
+	}
+	// Rules which aren't mirrored by FieldRules keep the validator tag as message
+	modelType := reflect.Indirect(reflect.ValueOf(record)).Type()
+	invalidFields := map[string]string{}
+	for _, fieldError := range validatorErrors {
+		name := fieldError.Field()
+		if field, ok := modelType.FieldByName(fieldError.StructField()); ok {
+			name = jsonFieldName(field)
+		}
+		invalidFields[name] = "failed the " + fieldError.Tag() + " rule"
+	}
+	return &ValidationError{Fields: invalidFields}
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func isNumberKind(fieldType reflect.Type) bool {
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func isEmail(text string) bool {
+	address, err := mail.ParseAddress(text)
+	return err == nil && address.Address == text
+}
+
+func isUrl(text string) bool {
+	parsed, err := url.ParseRequestURI(text)
+	return err == nil && parsed.Scheme != "" && parsed.Host != ""
+}
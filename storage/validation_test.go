@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+type validationTestItem struct {
+	Code  string `json:"code" binding:"required,len=3"`
+	Email string `json:"email" extras:"optional,email"`
+	Title string `json:"title" extras:"href:url,length:2-40"`
+	Count int    `json:"count" extras:"min:1,max:9"`
+	Zip   string `json:"zip" extras:"regex:^[0-9]{5}$"`
+}
+
+type validationTestBadBound struct {
+	Count int `json:"count" extras:"min:one"`
+}
+
+type validationTestBadRegex struct {
+	Zip string `json:"zip" extras:"regex:[0-9"`
+}
+
+func TestFieldRules(t *testing.T) {
+	modelType := reflect.TypeOf(validationTestItem{})
+	rules := map[string]*FieldRules{}
+	for i := 0; i < modelType.NumField(); i++ {
+		fieldRules, err := fieldRules(modelType.Field(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules[modelType.Field(i).Name] = fieldRules
+	}
+
+	if code := rules["Code"]; !code.Required || *code.MinLength != 3 || *code.MaxLength != 3 {
+		t.Errorf("Code rules = %+v", code)
+	}
+	if email := rules["Email"]; email.Required || email.Format != "email" {
+		t.Errorf("Email rules = %+v", email)
+	}
+	// The href:url extra isn't the url format
+	if title := rules["Title"]; title.Format != "" || *title.MinLength != 2 || *title.MaxLength != 40 {
+		t.Errorf("Title rules = %+v", title)
+	}
+	if count := rules["Count"]; *count.Min != 1 || *count.Max != 9 {
+		t.Errorf("Count rules = %+v", count)
+	}
+	if zip := rules["Zip"]; zip.pattern == nil || !zip.pattern.MatchString("11511") {
+		t.Errorf("Zip rules = %+v", zip)
+	}
+}
+
+func TestRegistryAddRejectsMalformedRules(t *testing.T) {
+	registry := NewRegistry()
+	for _, model := range []interface{}{&validationTestBadBound{}, &validationTestBadRegex{}} {
+		if _, err := registry.Add(model); err == nil {
+			t.Errorf("Add(%T) succeeded", model)
+		}
+	}
+	if names := registry.Names(); len(names) != 0 {
+		t.Errorf("Names() = %v, want none", names)
+	}
+}
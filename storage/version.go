@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ahmedsaleh747/go-creative-utils/apierrors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// errPreconditionRequired is returned when a versioned record is updated without If-Match
+var errPreconditionRequired = errors.New("the If-Match header is required to update this record")
+
+// versionConflict is returned when the If-Match header doesn't match the stored version
+type versionConflict struct {
+	version string
+}
+
+func (err *versionConflict) Error() string {
+	return "conflict: the record was modified by someone else, current version is " + err.version
+}
+
+func (err *versionConflict) Unwrap() error {
+	return apierrors.ErrConflict
+}
+
+// versionField returns the column used for optimistic locking, an integer field marked with
+// extras:"version" (counter) which is incremented on every update, or else the autoUpdateTime field
+func versionField(db *gorm.DB, model interface{}) (field *schema.Field, counter bool) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, false
+	}
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName != "" && strings.Contains(field.Tag.Get("extras"), "version") {
+			return field, true
+		}
+	}
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName != "" && field.AutoUpdateTime > 0 {
+			return field, false
+		}
+	}
+	return nil, false
+}
+
+// recordVersion is the version of the record as sent in the ETag, times are kept to the microseconds
+// stored by postgres
+func recordVersion(db *gorm.DB, record interface{}) (string, bool) {
+	field, _ := versionField(db, record)
+	if field == nil {
+		return "", false
+	}
+	value, _ := field.ValueOf(db.Statement.Context, reflect.ValueOf(record).Elem())
+	switch version := auditValue(value).(type) {
+	case time.Time:
+		return version.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano), true
+	case nil:
+		return "", true
+	default:
+		return fmt.Sprint(version), true
+	}
+}
+
+func setETag(c *gin.Context, db *gorm.DB, record interface{}) {
+	if version, ok := recordVersion(db, record); ok {
+		c.Header("ETag", `"`+version+`"`)
+	}
+}
+
+// checkVersion compares the If-Match header of the request with the stored record
+func checkVersion(c *gin.Context, db *gorm.DB, record interface{}) error {
+	return matchVersion(db, record, c.GetHeader("If-Match"))
+}
+
+func matchVersion(db *gorm.DB, record interface{}, ifMatch string) error {
+	version, ok := recordVersion(db, record)
+	if !ok {
+		return nil
+	}
+	ifMatch = strings.TrimSpace(ifMatch)
+	if ifMatch == "" {
+		return errPreconditionRequired
+	}
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.Trim(strings.TrimPrefix(tag, "W/"), `"`) == version {
+			return nil
+		}
+	}
+	return &versionConflict{version: version}
+}
+
+// bumpVersion increments the counter version of the record, the time based versions are set by gorm
+func bumpVersion(db *gorm.DB, record interface{}) error {
+	field, counter := versionField(db, record)
+	if field == nil || !counter {
+		return nil
+	}
+	recordValue := reflect.ValueOf(record).Elem()
+	value, _ := field.ValueOf(db.Statement.Context, recordValue)
+	reflectValue := reflect.ValueOf(value)
+	switch {
+	case reflectValue.CanInt():
+		return field.Set(db.Statement.Context, recordValue, reflectValue.Int()+1)
+	case reflectValue.CanUint():
+		return field.Set(db.Statement.Context, recordValue, reflectValue.Uint()+1)
+	}
+	return fmt.Errorf("version field %s must be an integer", field.Name)
+}
+
+// respondVersionError answers 428 when If-Match is missing, or 409 with the current record so the client
+// can merge its changes
+func respondVersionError(c *gin.Context, db *gorm.DB, record interface{}, err error) {
+	apiErr := apiError(err, apierrors.Conflict)
+	if apiErr.Code == apierrors.Conflict {
+		setETag(c, db, record)
+		apiErr.With("current", record)
+	}
+	apierrors.Respond(c, apiErr)
+}
+
+// keepVersion undoes a counter version sent in the request body, it only moves through bumpVersion
+func keepVersion(db *gorm.DB, record interface{}, stored interface{}) error {
+	field, counter := versionField(db, record)
+	if field == nil || !counter {
+		return nil
+	}
+	value, _ := field.ValueOf(db.Statement.Context, reflect.ValueOf(stored).Elem())
+	return field.Set(db.Statement.Context, reflect.ValueOf(record).Elem(), value)
+}
+
+// keepPrimaryKey undoes a primary key sent in the request body, so the body can't move the update to
+// another row, outside of the scope and the version check
+func keepPrimaryKey(db *gorm.DB, record interface{}, stored interface{}) error {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(record); err != nil {
+		return err
+	}
+	for _, field := range stmt.Schema.PrimaryFields {
+		value, _ := field.ValueOf(db.Statement.Context, reflect.ValueOf(stored).Elem())
+		if err := field.Set(db.Statement.Context, reflect.ValueOf(record).Elem(), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bodyVersion is the version sent within a bulk item as an If-Match value, empty when it's missing
+func bodyVersion(db *gorm.DB, item interface{}) string {
+	field, _ := versionField(db, item)
+	if field == nil {
+		return ""
+	}
+	if _, isZero := field.ValueOf(db.Statement.Context, reflect.ValueOf(item).Elem()); isZero {
+		return ""
+	}
+	version, _ := recordVersion(db, item)
+	return `"` + version + `"`
+}